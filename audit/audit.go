@@ -0,0 +1,374 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"echo-demo/config"
+	"echo-demo/db"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// Keys whose values never make it into the log.
+var secrets = []string{"password", "secret", "token", "code"}
+
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+type Event struct {
+	ID        int64              `json:"id"`
+	Time      time.Time          `json:"time"`
+	ActorID   int64              `json:"actor_id,omitempty"`
+	Action    string             `json:"action"`
+	TargetID  int64              `json:"target_id,omitempty"`
+	Diff      map[string]*Change `json:"diff,omitempty"`
+	IP        string             `json:"ip"`
+	RequestID string             `json:"request_id"`
+	PrevHash  string             `json:"prev_hash,omitempty"`
+	Hash      string             `json:"hash,omitempty"`
+}
+
+type Filter struct {
+	ActorID  int64
+	Action   string
+	TargetID int64
+	Since    *time.Time
+	Until    *time.Time
+	BeforeID int64
+	Limit    int64
+}
+
+type Verification struct {
+	Intact   bool  `json:"intact"`
+	BrokenID int64 `json:"broken_id"`
+}
+
+// Diff returns the fields that differ between before and after, either of
+// which may be nil, plus the changed fields named in changed. Secrets are
+// redacted on both sides.
+func Diff(before any, after any, changed ...string) map[string]*Change {
+	b, a := toMap(before), toMap(after)
+	diff := map[string]*Change{}
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			diff[k] = &Change{Before: v, After: a[k]}
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			diff[k] = &Change{After: v}
+		}
+	}
+
+	for _, k := range changed {
+		diff[k] = &Change{Before: redacted, After: redacted}
+	}
+
+	for k, c := range diff {
+		if isSecret(k) {
+			if c.Before != nil {
+				c.Before = redacted
+			}
+			if c.After != nil {
+				c.After = redacted
+			}
+		}
+	}
+
+	return diff
+}
+
+func toMap(v any) map[string]any {
+	m := map[string]any{}
+	if v == nil {
+		return m
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return m
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return m
+	}
+	json.Unmarshal(data, &m)
+
+	return m
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secrets {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record appends an event. With the hash chain on, each row carries the
+// hash of its predecessor, so that rewriting history breaks the chain.
+// Events about a change are written with Commit instead.
+func Record(ctx context.Context, e *Event) error {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+
+	if !config.AuditHashChain() {
+		return write(ctx, db.Conn(), e)
+	}
+
+	return db.WithTx(ctx, func(tx *sql.Tx) error {
+		return write(ctx, tx, e)
+	})
+}
+
+type resultOf struct{}
+
+// Result stands for the after of a pending event when it is whatever the
+// change comes out as.
+var Result any = resultOf{}
+
+type pendingKey struct{}
+
+type pending struct {
+	e       Event
+	before  any
+	after   any
+	secrets []string
+}
+
+// Pending attaches an event to ctx for the change it describes to write
+// with Commit, in the same transaction. Its diff is taken from before and
+// after, or the result given to Commit when after is Result.
+func Pending(ctx context.Context, e *Event, before any, after any, secrets ...string) context.Context {
+	return context.WithValue(ctx, pendingKey{}, &pending{*e, before, after, secrets})
+}
+
+// Commit writes the event pending in ctx, if any, within tx, so that the
+// change and its record are kept or lost together. It is called once per
+// target, right before tx is committed.
+func Commit(ctx context.Context, tx *sql.Tx, targetID int64, result any) error {
+	p, ok := ctx.Value(pendingKey{}).(*pending)
+	if !ok {
+		return nil
+	}
+
+	e := p.e
+	e.TargetID = targetID
+	after := p.after
+	if _, ok := after.(resultOf); ok {
+		after = result
+	}
+	e.Diff = Diff(p.before, after, p.secrets...)
+
+	return write(ctx, tx, &e)
+}
+
+// write inserts e, chained to the head of the chain when it is on. The
+// single row of audit_chain holds the head, and locking it lines up every
+// writer, whichever server it runs on, so q must be a transaction then.
+func write(ctx context.Context, q db.Querier, e *Event) error {
+	e.Time = time.Now().UTC().Truncate(time.Microsecond)
+
+	diff, err := json.Marshal(e.Diff)
+	if err != nil {
+		return err
+	}
+
+	if !config.AuditHashChain() {
+		_, err = insert(ctx, q, e, diff)
+		return err
+	}
+
+	var prev sql.NullString
+	if err := q.QueryRowContext(ctx, "SELECT hash FROM audit_chain WHERE id = 1 FOR UPDATE").Scan(&prev); err != nil {
+		return err
+	}
+	e.PrevHash = prev.String
+	e.Hash = hash(e, diff)
+
+	if _, err := insert(ctx, q, e, diff); err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, "UPDATE audit_chain SET hash = ? WHERE id = 1", e.Hash)
+	return err
+}
+
+func insert(ctx context.Context, q db.Querier, e *Event, diff []byte) (int64, error) {
+	result, err := q.ExecContext(ctx, "INSERT INTO audit_log(created_at, actor_id, action, target_id, diff, ip, request_id, prev_hash, hash) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		e.Time, nullInt(e.ActorID), e.Action, nullInt(e.TargetID), diff, e.IP, e.RequestID, nullString(e.PrevHash), nullString(e.Hash))
+	if err != nil {
+		return 0, err
+	}
+
+	e.ID, _ = result.LastInsertId()
+	return e.ID, nil
+}
+
+func hash(e *Event, diff []byte) string {
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s\n%s\n%d\n%s\n%d\n%s\n%s\n%s\n",
+		e.PrevHash, e.Time.UTC().Format(time.RFC3339Nano), e.ActorID, e.Action, e.TargetID, diff, e.IP, e.RequestID)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+const eventFields = "id, created_at, actor_id, action, target_id, diff, ip, request_id, prev_hash, hash"
+
+func scanEvent(row interface{ Scan(...any) error }) (e *Event, diff []byte, err error) {
+	e = new(Event)
+
+	var actor, target sql.NullInt64
+	var prev, h sql.NullString
+	if err := row.Scan(&e.ID, &e.Time, &actor, &e.Action, &target, &diff, &e.IP, &e.RequestID, &prev, &h); err != nil {
+		return nil, nil, err
+	}
+	e.Time = e.Time.UTC()
+	e.ActorID = actor.Int64
+	e.TargetID = target.Int64
+	e.PrevHash = prev.String
+	e.Hash = h.String
+
+	if len(diff) > 0 && string(diff) != "null" {
+		if err := json.Unmarshal(diff, &e.Diff); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return e, diff, nil
+}
+
+// Query returns matching events, newest first.
+func Query(ctx context.Context, f *Filter) (events []*Event, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+
+	var conds []string
+	var args []any
+	if f.ActorID > 0 {
+		conds = append(conds, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if len(f.Action) > 0 {
+		// A trailing dot selects a whole family, such as "user.".
+		if strings.HasSuffix(f.Action, ".") {
+			conds = append(conds, "action LIKE ?")
+			args = append(args, f.Action+"%")
+		} else {
+			conds = append(conds, "action = ?")
+			args = append(args, f.Action)
+		}
+	}
+	if f.TargetID > 0 {
+		conds = append(conds, "target_id = ?")
+		args = append(args, f.TargetID)
+	}
+	if f.Since != nil {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if f.Until != nil {
+		conds = append(conds, "created_at < ?")
+		args = append(args, f.Until.UTC())
+	}
+	if f.BeforeID > 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, f.BeforeID)
+	}
+
+	sqlStr := "SELECT " + eventFields + " FROM audit_log"
+	if len(conds) > 0 {
+		sqlStr += " WHERE " + strings.Join(conds, " AND ")
+	}
+	sqlStr += " ORDER BY id DESC LIMIT " + fmt.Sprintf("%d", f.Limit)
+
+	conn := db.Conn()
+	st, err := conn.PrepareContext(ctx, sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	rows, err := st.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events = make([]*Event, 0, f.Limit)
+	for rows.Next() {
+		e, _, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Verify walks the hash chain from the start and returns the id of the
+// first row that does not fit, or 0 when the chain is intact. Rows written
+// before the chain was turned on are skipped, but once it is on every row
+// has to be part of it.
+func Verify(ctx context.Context) (brokenID int64, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+
+	conn := db.Conn()
+	rows, err := conn.QueryContext(ctx, "SELECT "+eventFields+" FROM audit_log ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	c := new(chain)
+	for rows.Next() {
+		e, diff, err := scanEvent(rows)
+		if err != nil {
+			return 0, err
+		}
+		if !c.next(e, diff) {
+			return e.ID, nil
+		}
+	}
+
+	return 0, rows.Err()
+}
+
+// chain follows the hash chain one row at a time, in id order.
+type chain struct {
+	prev string
+}
+
+// next tells whether e, with its diff as stored, fits onto the rows before
+// it. Rows without a hash can only come before the first one with.
+func (c *chain) next(e *Event, diff []byte) bool {
+	if len(e.Hash) == 0 {
+		return len(c.prev) == 0
+	}
+	if e.PrevHash != c.prev || e.Hash != hash(e, diff) {
+		return false
+	}
+	c.prev = e.Hash
+	return true
+}
+
+func nullInt(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: n > 0}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: len(s) > 0}
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+type row struct {
+	e    *Event
+	diff []byte
+}
+
+// rows makes a chain of five events the way write does. The first is
+// written before the chain is turned on.
+func rows(t *testing.T) []*row {
+	t.Helper()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	prev := ""
+	var rs []*row
+	for i := int64(1); i <= 5; i++ {
+		e := &Event{
+			ID:        i,
+			Time:      start.Add(time.Duration(i) * time.Second),
+			ActorID:   1,
+			Action:    "user.update",
+			TargetID:  i + 10,
+			Diff:      Diff(map[string]int64{"age": i}, map[string]int64{"age": i + 1}),
+			IP:        "192.0.2.1",
+			RequestID: "req",
+		}
+		diff, err := json.Marshal(e.Diff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 1 {
+			e.PrevHash = prev
+			e.Hash = hash(e, diff)
+			prev = e.Hash
+		}
+		rs = append(rs, &row{e, diff})
+	}
+	return rs
+}
+
+func brokenAt(rs []*row) int64 {
+	c := new(chain)
+	for _, r := range rs {
+		if !c.next(r.e, r.diff) {
+			return r.e.ID
+		}
+	}
+	return 0
+}
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(rs []*row) []*row
+		want   int64
+	}{
+		{"intact", func(rs []*row) []*row { return rs }, 0},
+		{"empty", func(rs []*row) []*row { return nil }, 0},
+		{"action", func(rs []*row) []*row { rs[2].e.Action = "user.create"; return rs }, 3},
+		{"actor", func(rs []*row) []*row { rs[1].e.ActorID = 2; return rs }, 2},
+		{"target", func(rs []*row) []*row { rs[4].e.TargetID = 1; return rs }, 5},
+		{"time", func(rs []*row) []*row { rs[3].e.Time = rs[3].e.Time.Add(time.Microsecond); return rs }, 4},
+		{"diff", func(rs []*row) []*row { rs[3].diff = []byte(`{}`); return rs }, 4},
+		{"ip", func(rs []*row) []*row { rs[1].e.IP = "198.51.100.1"; return rs }, 2},
+		{"deleted", func(rs []*row) []*row { return slices.Delete(rs, 2, 3) }, 4},
+		{"deleted first", func(rs []*row) []*row { return slices.Delete(rs, 1, 2) }, 3},
+		{"swapped", func(rs []*row) []*row { rs[3], rs[4] = rs[4], rs[3]; return rs }, 5},
+		{"rehashed", func(rs []*row) []*row {
+			rs[2].e.Action = "user.create"
+			rs[2].e.Hash = hash(rs[2].e, rs[2].diff)
+			return rs
+		}, 4},
+		{"unhashed", func(rs []*row) []*row { rs[3].e.Hash = ""; return rs }, 4},
+		{"unhashed last", func(rs []*row) []*row { rs[4].e.Hash = ""; return rs }, 5},
+		{"before the chain", func(rs []*row) []*row { rs[0].e.Action = "user.create"; return rs }, 0},
+	}
+	for _, tt := range tests {
+		if got := brokenAt(tt.tamper(rows(t))); got != tt.want {
+			t.Errorf("%s: broken at %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
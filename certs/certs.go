@@ -0,0 +1,186 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"echo-demo/config"
+	"echo-demo/logging"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var log = logging.For("certs")
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Server hands out the TLS config of the public server. The certificate
+// and client CAs are read again when their files change, new connections
+// get them, established ones keep what they started with.
+type Server struct {
+	current atomic.Pointer[tls.Config]
+	stamps  []time.Time
+}
+
+// Load reads the files the config names and checks its TLS settings.
+func Load() (*Server, error) {
+	s := new(Server)
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Config is the config to listen with. It defers to the latest files for
+// every handshake.
+func (s *Server) Config() *tls.Config {
+	cfg := s.current.Load().Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return s.current.Load(), nil
+	}
+	return cfg
+}
+
+// Watch reloads the files whenever they change, until ctx is done. A
+// reload that fails, as when the key is written after the certificate,
+// keeps the files loaded before and is tried again on the next check.
+func (s *Server) Watch(ctx context.Context) {
+	interval := config.TLSReloadInterval()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+			if err := s.load(); err != nil {
+				log.WarnContext(ctx, "certificate reload failed", "err", err)
+				continue
+			}
+			log.InfoContext(ctx, "certificate reloaded", "cert", config.TLSCert())
+		}
+	}
+}
+
+func (s *Server) load() error {
+	stamps, err := modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.TLSCert(), config.TLSKey())
+	if err != nil {
+		return err
+	}
+
+	minVersion, ok := versions[config.TLSMinVersion()]
+	if !ok {
+		return fmt.Errorf("TLS: MinVersion(%s) Invalid", config.TLSMinVersion())
+	}
+	suites, err := cipherSuites(config.TLSCipherSuites())
+	if err != nil {
+		return err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+		NextProtos:   []string{"http/1.1"},
+	}
+	if config.HTTP2() {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	if ca := config.TLSClientCA(); len(ca) > 0 {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("TLS: ClientCA(%s) Invalid", ca)
+		}
+		switch config.TLSClientAuth() {
+		case "optional":
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		case "require":
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		default:
+			return fmt.Errorf("TLS: ClientAuth(%s) Invalid", config.TLSClientAuth())
+		}
+	}
+
+	s.current.Store(cfg)
+	s.stamps = stamps
+	return nil
+}
+
+// changed tells whether any of the files was written since the last load.
+func (s *Server) changed() bool {
+	stamps, err := modTimes()
+	if err != nil {
+		// Missing for a moment during a rotation, most likely.
+		return false
+	}
+	for i := range stamps {
+		if !stamps[i].Equal(s.stamps[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func modTimes() ([]time.Time, error) {
+	files := []string{config.TLSCert(), config.TLSKey()}
+	if ca := config.TLSClientCA(); len(ca) > 0 {
+		files = append(files, ca)
+	}
+
+	stamps := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		stamps[i] = info.ModTime()
+	}
+	return stamps, nil
+}
+
+// cipherSuites looks names up among the suites crypto/tls deems secure.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	var errs []error
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("TLS: CipherSuite(%s) Invalid", name))
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, errors.Join(errs...)
+}
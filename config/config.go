@@ -12,16 +12,129 @@ import (
 )
 
 type DemoConfig struct {
-	ServerAddr   string `json:"server_addr"`
-	AdminAddr    string `json:"admin_addr"`
-	SignKey      string `json:"sign_key"`
-	VerifyKey    string `json:"verify_key"`
-	SessionKey   string `json:"session_key"`
-	DbName       string `json:"db_name"`
-	DbURL        string `json:"db_url"`
-	ValkeyURL    string `json:"valkey_url"`
-	RecordLimit  int    `json:"record_limit"`
-	RecordOffset int    `json:"record_offset"`
+	ServerAddr  string `json:"server_addr"`
+	AdminAddr   string `json:"admin_addr"`
+	SignKey     string `json:"sign_key"`
+	VerifyKey   string `json:"verify_key"`
+	SessionKey  string `json:"session_key"`
+	DbName      string `json:"db_name"`
+	DbURL       string `json:"db_url"`
+	ValkeyURL   string `json:"valkey_url"`
+	RecordLimit int    `json:"record_limit"`
+	RecordMax   int    `json:"record_max"`
+	CursorKey   string `json:"cursor_key"`
+
+	TOTPIssuer    string `json:"totp_issuer"`
+	ChallengeTTL  int    `json:"challenge_ttl"`
+	AdminForce2FA bool   `json:"admin_force_2fa"`
+
+	ChallengeMaxAttempts int `json:"challenge_max_attempts"`
+
+	BaseURL        string `json:"base_url"`
+	ResetTokenTTL  int    `json:"reset_token_ttl"`
+	VerifyTokenTTL int    `json:"verify_token_ttl"`
+
+	Mailer       string `json:"mailer"`
+	MailFrom     string `json:"mail_from"`
+	MailFile     string `json:"mail_file"`
+	SMTPAddr     string `json:"smtp_addr"`
+	SMTPUser     string `json:"smtp_user"`
+	SMTPPassword string `json:"smtp_password"`
+
+	PasswordMinLength     int    `json:"password_min_length"`
+	PasswordMaxLength     int    `json:"password_max_length"`
+	PasswordRequireUpper  bool   `json:"password_require_upper"`
+	PasswordRequireLower  bool   `json:"password_require_lower"`
+	PasswordRequireDigit  bool   `json:"password_require_digit"`
+	PasswordRequireSymbol bool   `json:"password_require_symbol"`
+	PasswordDisallowName  bool   `json:"password_disallow_name"`
+	BreachedList          string `json:"breached_list"`
+
+	Argon2Memory      int `json:"argon2_memory"`
+	Argon2Iterations  int `json:"argon2_iterations"`
+	Argon2Parallelism int `json:"argon2_parallelism"`
+	Argon2SaltLength  int `json:"argon2_salt_length"`
+	Argon2KeyLength   int `json:"argon2_key_length"`
+
+	RequireIfMatch bool `json:"require_if_match"`
+
+	PurgeInterval  int `json:"purge_interval"`
+	PurgeRetention int `json:"purge_retention"`
+
+	AuditHashChain bool `json:"audit_hash_chain"`
+
+	QueryTimeout int `json:"query_timeout"`
+
+	MaxOpenConns         int      `json:"max_open_conns"`
+	MaxIdleConns         int      `json:"max_idle_conns"`
+	ConnMaxLifetime      int      `json:"conn_max_lifetime"`
+	ConnMaxIdleTime      int      `json:"conn_max_idle_time"`
+	DbReplicas           []string `json:"db_replicas"`
+	ReplicaCheckInterval int      `json:"replica_check_interval"`
+	ReplicaLag           int      `json:"replica_lag"`
+
+	RetryAttempts   int `json:"retry_attempts"`
+	RetryDelay      int `json:"retry_delay"`
+	RetryMaxDelay   int `json:"retry_max_delay"`
+	MonitorInterval int `json:"monitor_interval"`
+
+	Debug bool `json:"debug"`
+
+	ValidateRequests bool `json:"validate_requests"`
+
+	LogFormat string   `json:"log_format"`
+	LogLevel  string   `json:"log_level"`
+	LogLevels []string `json:"log_levels"`
+
+	TraceExporter      string `json:"trace_exporter"`
+	TraceEndpoint      string `json:"trace_endpoint"`
+	TraceFile          string `json:"trace_file"`
+	TraceSamplePercent int    `json:"trace_sample_percent"`
+
+	DiagDir           string `json:"diag_dir"`
+	CaptureMaxSeconds int    `json:"capture_max_seconds"`
+
+	Middleware MiddlewareConfig `json:"middleware"`
+
+	TLSCert           string   `json:"tls_cert"`
+	TLSKey            string   `json:"tls_key"`
+	TLSMinVersion     string   `json:"tls_min_version"`
+	TLSCipherSuites   []string `json:"tls_cipher_suites"`
+	TLSReloadInterval int      `json:"tls_reload_interval"`
+	HTTP2             bool     `json:"http2"`
+	RedirectAddr      string   `json:"redirect_addr"`
+	TLSClientCA       string   `json:"tls_client_ca"`
+	TLSClientAuth     string   `json:"tls_client_auth"`
+	ClientCertUsers   []string `json:"client_cert_users"`
+}
+
+// MiddlewareConfig is the middleware section, it shapes the HTTP side of
+// the public server.
+type MiddlewareConfig struct {
+	CORSOrigins     []string `json:"cors_origins"`
+	CORSMethods     []string `json:"cors_methods"`
+	CORSHeaders     []string `json:"cors_headers"`
+	CORSCredentials bool     `json:"cors_credentials"`
+	CORSMaxAge      int      `json:"cors_max_age"`
+
+	HSTSMaxAge            int    `json:"hsts_max_age"`
+	HSTSSubdomains        bool   `json:"hsts_subdomains"`
+	ContentSecurityPolicy string `json:"content_security_policy"`
+	FrameOptions          string `json:"frame_options"`
+	ContentTypeNosniff    bool   `json:"content_type_nosniff"`
+	ReferrerPolicy        string `json:"referrer_policy"`
+
+	BodyLimit  string   `json:"body_limit"`
+	BodyLimits []string `json:"body_limits"`
+
+	RequestTimeout    int      `json:"request_timeout"`
+	TimeoutExempt     []string `json:"timeout_exempt"`
+	ReadHeaderTimeout int      `json:"read_header_timeout"`
+	IdleTimeout       int      `json:"idle_timeout"`
+
+	Compression       []string `json:"compression"`
+	CompressionLevel  int      `json:"compression_level"`
+	CompressionMinLen int      `json:"compression_min_length"`
 }
 
 // Default values
@@ -39,8 +152,101 @@ var config = DemoConfig{
 
 	ValkeyURL: "redis://localhost:6379",
 
-	RecordLimit:  5,
-	RecordOffset: 0,
+	RecordLimit: 5,
+	RecordMax:   100,
+	CursorKey:   "secret",
+
+	TOTPIssuer:    "echo-demo",
+	ChallengeTTL:  300,
+	AdminForce2FA: false,
+
+	ChallengeMaxAttempts: 5,
+
+	BaseURL:        "http://localhost:8080",
+	ResetTokenTTL:  3600,
+	VerifyTokenTTL: 86400,
+
+	Mailer:   "log",
+	MailFrom: "echo-demo@localhost",
+	MailFile: "./mail.log",
+	SMTPAddr: "localhost:25",
+
+	PasswordMinLength:    8,
+	PasswordMaxLength:    128,
+	PasswordRequireLower: true,
+	PasswordRequireDigit: true,
+	PasswordDisallowName: true,
+
+	Argon2Memory:      64 * 1024,
+	Argon2Iterations:  3,
+	Argon2Parallelism: 4,
+	Argon2SaltLength:  16,
+	Argon2KeyLength:   32,
+
+	RequireIfMatch: false,
+
+	PurgeInterval:  3600,
+	PurgeRetention: 30,
+
+	AuditHashChain: true,
+
+	QueryTimeout: 5,
+
+	MaxOpenConns:         25,
+	MaxIdleConns:         10,
+	ConnMaxLifetime:      300,
+	ConnMaxIdleTime:      60,
+	ReplicaCheckInterval: 5,
+	ReplicaLag:           5,
+
+	RetryAttempts:   10,
+	RetryDelay:      500,
+	RetryMaxDelay:   30000,
+	MonitorInterval: 10,
+
+	Debug: false,
+
+	ValidateRequests: true,
+
+	LogFormat: "text",
+	LogLevel:  "info",
+
+	TraceExporter:      "none",
+	TraceEndpoint:      "http://localhost:4318",
+	TraceFile:          "./traces.json",
+	TraceSamplePercent: 100,
+
+	DiagDir:           "./diag",
+	CaptureMaxSeconds: 60,
+
+	Middleware: MiddlewareConfig{
+		CORSMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		CORSHeaders: []string{"Authorization", "Content-Type", "Accept", "Accept-Language", "If-Match", "If-None-Match", "X-Request-ID"},
+		CORSMaxAge:  600,
+
+		HSTSMaxAge:            31536000,
+		ContentSecurityPolicy: "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; font-src 'self' data:; worker-src 'self' blob:; frame-ancestors 'none'",
+		FrameOptions:          "DENY",
+		ContentTypeNosniff:    true,
+		ReferrerPolicy:        "strict-origin-when-cross-origin",
+
+		BodyLimit:  "1M",
+		BodyLimits: []string{"/v1/upload=32M", "/v1/users/import=16M"},
+
+		RequestTimeout:    30,
+		TimeoutExempt:     []string{"/v1/users/export", "/v1/users/import"},
+		ReadHeaderTimeout: 10,
+		IdleTimeout:       120,
+
+		Compression:       []string{"br", "gzip"},
+		CompressionLevel:  5,
+		CompressionMinLen: 1024,
+	},
+
+	TLSMinVersion:     "1.2",
+	TLSReloadInterval: 10,
+	HTTP2:             true,
+	TLSClientAuth:     "optional",
 }
 
 func ServerAddr() string {
@@ -79,8 +285,331 @@ func RecordLimit() int {
 	return config.RecordLimit
 }
 
-func RecordOffset() int {
-	return config.RecordOffset
+// RecordMax caps the page size a client can ask for.
+func RecordMax() int {
+	return config.RecordMax
+}
+
+func CursorKey() []byte {
+	return []byte(config.CursorKey)
+}
+
+func TOTPIssuer() string {
+	return config.TOTPIssuer
+}
+
+// ChallengeTTL is the lifetime of a 2FA login challenge.
+func ChallengeTTL() time.Duration {
+	return time.Duration(config.ChallengeTTL) * time.Second
+}
+
+// ChallengeMaxAttempts is how many codes a 2FA login challenge takes, and
+// how many wrong ones a user may send within ChallengeTTL, before a new
+// login is needed.
+func ChallengeMaxAttempts() int {
+	return config.ChallengeMaxAttempts
+}
+
+func AdminForce2FA() bool {
+	return config.AdminForce2FA
+}
+
+func BaseURL() string {
+	return config.BaseURL
+}
+
+func ResetTokenTTL() time.Duration {
+	return time.Duration(config.ResetTokenTTL) * time.Second
+}
+
+func VerifyTokenTTL() time.Duration {
+	return time.Duration(config.VerifyTokenTTL) * time.Second
+}
+
+// Mailer is one of "smtp", "file" or "log".
+func Mailer() string {
+	return config.Mailer
+}
+
+func MailFrom() string {
+	return config.MailFrom
+}
+
+func MailFile() string {
+	return config.MailFile
+}
+
+func SMTPAddr() string {
+	return config.SMTPAddr
+}
+
+func SMTPUser() string {
+	return config.SMTPUser
+}
+
+func SMTPPassword() string {
+	return config.SMTPPassword
+}
+
+func PasswordMinLength() int {
+	return config.PasswordMinLength
+}
+
+// PasswordMaxLength caps the work an attacker can make argon2 do per
+// request. Zero means no limit.
+func PasswordMaxLength() int {
+	return config.PasswordMaxLength
+}
+
+func PasswordRequireUpper() bool {
+	return config.PasswordRequireUpper
+}
+
+func PasswordRequireLower() bool {
+	return config.PasswordRequireLower
+}
+
+func PasswordRequireDigit() bool {
+	return config.PasswordRequireDigit
+}
+
+func PasswordRequireSymbol() bool {
+	return config.PasswordRequireSymbol
+}
+
+func PasswordDisallowName() bool {
+	return config.PasswordDisallowName
+}
+
+// BreachedList is the path of the breached password list, empty to skip
+// the check.
+func BreachedList() string {
+	return config.BreachedList
+}
+
+// Argon2Memory is in KiB. Hashes made with lower parameters than these
+// are upgraded on the next successful login.
+func Argon2Memory() int {
+	return config.Argon2Memory
+}
+
+func Argon2Iterations() int {
+	return config.Argon2Iterations
+}
+
+func Argon2Parallelism() int {
+	return config.Argon2Parallelism
+}
+
+func Argon2SaltLength() int {
+	return config.Argon2SaltLength
+}
+
+func Argon2KeyLength() int {
+	return config.Argon2KeyLength
+}
+
+// RequireIfMatch makes If-Match mandatory on PUT, PATCH and DELETE.
+func RequireIfMatch() bool {
+	return config.RequireIfMatch
+}
+
+// PurgeInterval is how often soft deleted users are looked for, in seconds.
+func PurgeInterval() time.Duration {
+	return time.Duration(config.PurgeInterval) * time.Second
+}
+
+// PurgeRetention is how long soft deleted users are kept, in days.
+func PurgeRetention() time.Duration {
+	return time.Duration(config.PurgeRetention) * 24 * time.Hour
+}
+
+// AuditHashChain links every audit row to the hash of the one before.
+// Turning it off again breaks the chain for audit.Verify.
+func AuditHashChain() bool {
+	return config.AuditHashChain
+}
+
+// QueryTimeout bounds each query or transaction, in seconds. 0 leaves
+// them to the request alone.
+func QueryTimeout() time.Duration {
+	return time.Duration(config.QueryTimeout) * time.Second
+}
+
+// MaxOpenConns and MaxIdleConns size each pool, 0 means no limit for
+// the former and no idle connections for the latter.
+func MaxOpenConns() int {
+	return config.MaxOpenConns
+}
+
+func MaxIdleConns() int {
+	return config.MaxIdleConns
+}
+
+// ConnMaxLifetime is how long a connection is reused at most, in seconds.
+func ConnMaxLifetime() time.Duration {
+	return time.Duration(config.ConnMaxLifetime) * time.Second
+}
+
+// ConnMaxIdleTime is how long a connection may sit idle, in seconds.
+func ConnMaxIdleTime() time.Duration {
+	return time.Duration(config.ConnMaxIdleTime) * time.Second
+}
+
+// DbReplicas are the DSNs of read replicas of the database at DbURL.
+func DbReplicas() []string {
+	return config.DbReplicas
+}
+
+// ReplicaCheckInterval is how often replicas are pinged, in seconds.
+func ReplicaCheckInterval() time.Duration {
+	return time.Duration(config.ReplicaCheckInterval) * time.Second
+}
+
+// ReplicaLag is how long a client reads from the primary after it wrote,
+// in seconds, which should be no less than the replicas fall behind.
+func ReplicaLag() time.Duration {
+	return time.Duration(config.ReplicaLag) * time.Second
+}
+
+// RetryAttempts is how often a dependency is tried at startup before
+// giving up.
+func RetryAttempts() int {
+	return config.RetryAttempts
+}
+
+// RetryDelay is the first wait between startup attempts, in milliseconds.
+// It doubles with every attempt up to RetryMaxDelay.
+func RetryDelay() time.Duration {
+	return time.Duration(config.RetryDelay) * time.Millisecond
+}
+
+func RetryMaxDelay() time.Duration {
+	return time.Duration(config.RetryMaxDelay) * time.Millisecond
+}
+
+// MonitorInterval is how often dependencies are pinged once running, in
+// seconds.
+func MonitorInterval() time.Duration {
+	return time.Duration(config.MonitorInterval) * time.Second
+}
+
+// Debug shows internal errors to clients. Never in production.
+func Debug() bool {
+	return config.Debug
+}
+
+// ValidateRequests rejects requests that do not match the OpenAPI
+// document before they reach the handlers.
+func ValidateRequests() bool {
+	return config.ValidateRequests
+}
+
+// LogFormat is json or text.
+func LogFormat() string {
+	return config.LogFormat
+}
+
+func LogLevel() string {
+	return config.LogLevel
+}
+
+// LogLevels overrides LogLevel per package, like users=debug.
+func LogLevels() []string {
+	return config.LogLevels
+}
+
+// TraceExporter is none, otlp, stdout or file.
+func TraceExporter() string {
+	return config.TraceExporter
+}
+
+// TraceEndpoint is the URL of an OTLP/HTTP collector.
+func TraceEndpoint() string {
+	return config.TraceEndpoint
+}
+
+func TraceFile() string {
+	return config.TraceFile
+}
+
+// TraceSampleRatio is the share of new traces that are recorded.
+func TraceSampleRatio() float64 {
+	return float64(config.TraceSamplePercent) / 100
+}
+
+// DiagDir is where captured profiles and traces are written.
+func DiagDir() string {
+	return config.DiagDir
+}
+
+// CaptureMaxSeconds bounds how long a capture may run.
+func CaptureMaxSeconds() int {
+	return config.CaptureMaxSeconds
+}
+
+// Middleware is the middleware section. CORS is off without origins and
+// compression without encodings. BodyLimits are route=limit pairs that
+// override BodyLimit, limits like 512K or 8M. RequestTimeout bounds the
+// context of a request in seconds, except on the TimeoutExempt routes.
+// The other timeouts are those of the server, in seconds too, and 0
+// leaves things unbounded.
+func Middleware() MiddlewareConfig {
+	return config.Middleware
+}
+
+// TLSCert and TLSKey are the PEM files of the public server, which serves
+// plain HTTP without them.
+func TLSCert() string {
+	return config.TLSCert
+}
+
+func TLSKey() string {
+	return config.TLSKey
+}
+
+// TLSMinVersion is 1.0, 1.1, 1.2 or 1.3.
+func TLSMinVersion() string {
+	return config.TLSMinVersion
+}
+
+// TLSCipherSuites are named as in crypto/tls, empty for its defaults.
+// They do not apply to TLS 1.3.
+func TLSCipherSuites() []string {
+	return config.TLSCipherSuites
+}
+
+// TLSReloadInterval is how often the certificate files are checked for
+// changes, in seconds. 0 loads them once.
+func TLSReloadInterval() time.Duration {
+	return time.Duration(config.TLSReloadInterval) * time.Second
+}
+
+func HTTP2() bool {
+	return config.HTTP2
+}
+
+// RedirectAddr is where plain HTTP is sent over to HTTPS, empty for
+// nowhere.
+func RedirectAddr() string {
+	return config.RedirectAddr
+}
+
+// TLSClientCA is the PEM file of the CAs client certificates are checked
+// against, empty to ask for none.
+func TLSClientCA() string {
+	return config.TLSClientCA
+}
+
+// TLSClientAuth is optional or require.
+func TLSClientAuth() string {
+	return config.TLSClientAuth
+}
+
+// ClientCertUsers map the common name of a client certificate to the ID
+// of the user it acts as, like billing=5.
+func ClientCertUsers() []string {
+	return config.ClientCertUsers
 }
 
 func Init() error {
@@ -107,16 +636,123 @@ func Etcd(endpoints string) error {
 	defer cli.Close()
 
 	params := map[string]any{
-		"server_addr":   &config.ServerAddr,
-		"admin_addr":    &config.AdminAddr,
-		"sign_key":      &config.SignKey,
-		"verify_key":    &config.VerifyKey,
-		"session_key":   &config.SessionKey,
-		"db_name":       &config.DbName,
-		"db_url":        &config.DbURL,
-		"valkey_url":    &config.ValkeyURL,
-		"record_limit":  &config.RecordLimit,
-		"record_offset": &config.RecordOffset,
+		"server_addr":  &config.ServerAddr,
+		"admin_addr":   &config.AdminAddr,
+		"sign_key":     &config.SignKey,
+		"verify_key":   &config.VerifyKey,
+		"session_key":  &config.SessionKey,
+		"db_name":      &config.DbName,
+		"db_url":       &config.DbURL,
+		"valkey_url":   &config.ValkeyURL,
+		"record_limit": &config.RecordLimit,
+		"record_max":   &config.RecordMax,
+		"cursor_key":   &config.CursorKey,
+
+		"totp_issuer":     &config.TOTPIssuer,
+		"challenge_ttl":   &config.ChallengeTTL,
+		"admin_force_2fa": &config.AdminForce2FA,
+
+		"challenge_max_attempts": &config.ChallengeMaxAttempts,
+
+		"base_url":         &config.BaseURL,
+		"reset_token_ttl":  &config.ResetTokenTTL,
+		"verify_token_ttl": &config.VerifyTokenTTL,
+
+		"mailer":        &config.Mailer,
+		"mail_from":     &config.MailFrom,
+		"mail_file":     &config.MailFile,
+		"smtp_addr":     &config.SMTPAddr,
+		"smtp_user":     &config.SMTPUser,
+		"smtp_password": &config.SMTPPassword,
+
+		"password_min_length":     &config.PasswordMinLength,
+		"password_max_length":     &config.PasswordMaxLength,
+		"password_require_upper":  &config.PasswordRequireUpper,
+		"password_require_lower":  &config.PasswordRequireLower,
+		"password_require_digit":  &config.PasswordRequireDigit,
+		"password_require_symbol": &config.PasswordRequireSymbol,
+		"password_disallow_name":  &config.PasswordDisallowName,
+		"breached_list":           &config.BreachedList,
+
+		"argon2_memory":      &config.Argon2Memory,
+		"argon2_iterations":  &config.Argon2Iterations,
+		"argon2_parallelism": &config.Argon2Parallelism,
+		"argon2_salt_length": &config.Argon2SaltLength,
+		"argon2_key_length":  &config.Argon2KeyLength,
+
+		"require_if_match": &config.RequireIfMatch,
+
+		"purge_interval":  &config.PurgeInterval,
+		"purge_retention": &config.PurgeRetention,
+
+		"audit_hash_chain": &config.AuditHashChain,
+
+		"query_timeout": &config.QueryTimeout,
+
+		"max_open_conns":         &config.MaxOpenConns,
+		"max_idle_conns":         &config.MaxIdleConns,
+		"conn_max_lifetime":      &config.ConnMaxLifetime,
+		"conn_max_idle_time":     &config.ConnMaxIdleTime,
+		"db_replicas":            &config.DbReplicas,
+		"replica_check_interval": &config.ReplicaCheckInterval,
+		"replica_lag":            &config.ReplicaLag,
+
+		"retry_attempts":   &config.RetryAttempts,
+		"retry_delay":      &config.RetryDelay,
+		"retry_max_delay":  &config.RetryMaxDelay,
+		"monitor_interval": &config.MonitorInterval,
+
+		"debug": &config.Debug,
+
+		"validate_requests": &config.ValidateRequests,
+
+		"log_format": &config.LogFormat,
+		"log_level":  &config.LogLevel,
+		"log_levels": &config.LogLevels,
+
+		"trace_exporter":       &config.TraceExporter,
+		"trace_endpoint":       &config.TraceEndpoint,
+		"trace_file":           &config.TraceFile,
+		"trace_sample_percent": &config.TraceSamplePercent,
+
+		"diag_dir":            &config.DiagDir,
+		"capture_max_seconds": &config.CaptureMaxSeconds,
+
+		"middleware.cors_origins":     &config.Middleware.CORSOrigins,
+		"middleware.cors_methods":     &config.Middleware.CORSMethods,
+		"middleware.cors_headers":     &config.Middleware.CORSHeaders,
+		"middleware.cors_credentials": &config.Middleware.CORSCredentials,
+		"middleware.cors_max_age":     &config.Middleware.CORSMaxAge,
+
+		"middleware.hsts_max_age":            &config.Middleware.HSTSMaxAge,
+		"middleware.hsts_subdomains":         &config.Middleware.HSTSSubdomains,
+		"middleware.content_security_policy": &config.Middleware.ContentSecurityPolicy,
+		"middleware.frame_options":           &config.Middleware.FrameOptions,
+		"middleware.content_type_nosniff":    &config.Middleware.ContentTypeNosniff,
+		"middleware.referrer_policy":         &config.Middleware.ReferrerPolicy,
+
+		"middleware.body_limit":  &config.Middleware.BodyLimit,
+		"middleware.body_limits": &config.Middleware.BodyLimits,
+
+		"middleware.request_timeout":     &config.Middleware.RequestTimeout,
+		"middleware.timeout_exempt":      &config.Middleware.TimeoutExempt,
+		"middleware.read_header_timeout": &config.Middleware.ReadHeaderTimeout,
+		"middleware.idle_timeout":        &config.Middleware.IdleTimeout,
+
+		"middleware.compression":            &config.Middleware.Compression,
+		"middleware.compression_level":      &config.Middleware.CompressionLevel,
+		"middleware.compression_min_length": &config.Middleware.CompressionMinLen,
+
+		"tls_cert":            &config.TLSCert,
+		"tls_key":             &config.TLSKey,
+		"tls_min_version":     &config.TLSMinVersion,
+		"tls_cipher_suites":   &config.TLSCipherSuites,
+		"tls_reload_interval": &config.TLSReloadInterval,
+		"http2":               &config.HTTP2,
+		"redirect_addr":       &config.RedirectAddr,
+		"tls_client_ca":       &config.TLSClientCA,
+		"tls_client_auth":     &config.TLSClientAuth,
+		"client_cert_users":   &config.ClientCertUsers,
 	}
 	for key, ptr := range params {
 		val, err := getKey(cli, key)
@@ -134,6 +770,13 @@ func Etcd(endpoints string) error {
 			if num, err := strconv.Atoi(val); err == nil {
 				*p = num
 			}
+		case *bool:
+			if b, err := strconv.ParseBool(val); err == nil {
+				*p = b
+			}
+		case *[]string:
+			// Lists are comma separated.
+			*p = strings.Split(val, ",")
 		}
 
 	}
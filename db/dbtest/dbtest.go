@@ -0,0 +1,88 @@
+// Package dbtest is a database/sql driver for tests, which answers the
+// statements a test runs by their SQL text, so that what is decided in Go
+// around them can be tested without MySQL.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// DB runs Query for the statements that return rows and Exec for the
+// others, which return the number of rows affected or an error such as a
+// *mysql.MySQLError. Transactions begin, commit and roll back as no-ops.
+type DB struct {
+	Query map[string]func(args []driver.Value) [][]driver.Value
+	Exec  map[string]func(args []driver.Value) (int64, error)
+}
+
+// Open returns a pool on f, closed when the test ends.
+func (f *DB) Open(t *testing.T) *sql.DB {
+	t.Helper()
+	conn := sql.OpenDB(f)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func (f *DB) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *DB) Driver() driver.Driver                        { return nil }
+
+func (f *DB) Prepare(query string) (driver.Stmt, error) {
+	_, isQuery := f.Query[query]
+	_, isExec := f.Exec[query]
+	if !isQuery && !isExec {
+		return nil, fmt.Errorf("dbtest: unexpected statement %q", query)
+	}
+	return &stmt{f, query}, nil
+}
+
+func (f *DB) Close() error              { return nil }
+func (f *DB) Begin() (driver.Tx, error) { return f, nil }
+func (f *DB) Commit() error             { return nil }
+func (f *DB) Rollback() error           { return nil }
+
+type stmt struct {
+	db    *DB
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	num, err := s.db.Exec[s.query](args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(num), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &rows{values: s.db.Query[s.query](args)}, nil
+}
+
+type rows struct {
+	values [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	if len(r.values) == 0 {
+		return nil
+	}
+	return make([]string, len(r.values[0]))
+}
+
+func (r *rows) Close() error { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
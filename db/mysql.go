@@ -1,9 +1,11 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"echo-demo/config"
 	"errors"
+	"sync/atomic"
 
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -11,26 +13,116 @@ import (
 var (
 	ErrDupRows  = errors.New("DB: Duplicate")
 	ErrNotFound = errors.New("DB: Not Found")
+	ErrVersion  = errors.New("DB: Version Mismatch")
 )
 
 var dbPool *sql.DB
 
+// ConnInit opens the primary and the replicas. Nothing is kept from an
+// attempt that fails, so that it can be tried again.
 func ConnInit() error {
-	db, err := sql.Open(config.DbName(), config.DbURL())
+	db, err := open(config.DbURL())
 	if err != nil {
 		return err
 	}
 
 	err = db.Ping()
 	if err != nil {
+		db.Close()
+		return err
+	}
+
+	rs, err := replicaInit()
+	if err != nil {
+		db.Close()
 		return err
 	}
 
 	dbPool = db
+	replicas = rs
 
 	return nil
 }
 
+func open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open(config.DbName(), dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(config.MaxOpenConns())
+	db.SetMaxIdleConns(config.MaxIdleConns())
+	db.SetConnMaxLifetime(config.ConnMaxLifetime())
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime())
+
+	return db, nil
+}
+
+// Conn is the primary. Writes that should make the rest of a request read
+// from the primary as well go through Writer instead.
 func Conn() *sql.DB {
 	return dbPool
 }
+
+// Ping checks the primary.
+func Ping(ctx context.Context) error {
+	return dbPool.PingContext(ctx)
+}
+
+// Timeout bounds ctx by the query timeout of the config. The result is
+// canceled along with ctx all the same.
+func Timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := config.QueryTimeout(); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+type stickyKey struct{}
+
+type stickiness struct {
+	primary atomic.Bool
+	wrote   atomic.Bool
+}
+
+// Sticky prepares ctx for read-your-writes: once a write has gone through
+// Writer with it, or right away if primary is set, Reader returns the
+// primary for the rest of it.
+func Sticky(ctx context.Context, primary bool) context.Context {
+	s := new(stickiness)
+	s.primary.Store(primary)
+	return context.WithValue(ctx, stickyKey{}, s)
+}
+
+func sticky(ctx context.Context) bool {
+	s, ok := ctx.Value(stickyKey{}).(*stickiness)
+	return ok && s.primary.Load()
+}
+
+// Wrote tells whether a write has gone through Writer with ctx, which
+// Sticky must have prepared.
+func Wrote(ctx context.Context) bool {
+	s, ok := ctx.Value(stickyKey{}).(*stickiness)
+	return ok && s.wrote.Load()
+}
+
+// Writer returns the primary and makes ctx stick to it.
+func Writer(ctx context.Context) *sql.DB {
+	if s, ok := ctx.Value(stickyKey{}).(*stickiness); ok {
+		s.primary.Store(true)
+		s.wrote.Store(true)
+	}
+	return dbPool
+}
+
+// Reader returns a healthy replica, or the primary when there is none or
+// ctx has written already.
+func Reader(ctx context.Context) *sql.DB {
+	if sticky(ctx) {
+		return dbPool
+	}
+	if r := nextReplica(); r != nil {
+		return r
+	}
+	return dbPool
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"echo-demo/config"
+	"sync/atomic"
+	"time"
+)
+
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+var (
+	replicas []*replica
+	next     atomic.Uint64
+)
+
+// replicaInit opens every replica. One that can not be reached now is only
+// left out until a check finds it healthy, it does not stop the start.
+func replicaInit() ([]*replica, error) {
+	rs := make([]*replica, 0, len(config.DbReplicas()))
+	for _, dsn := range config.DbReplicas() {
+		db, err := open(dsn)
+		if err != nil {
+			for _, r := range rs {
+				r.db.Close()
+			}
+			return nil, err
+		}
+
+		r := &replica{db: db}
+		r.healthy.Store(db.Ping() == nil)
+		rs = append(rs, r)
+	}
+
+	return rs, nil
+}
+
+// nextReplica takes turns among the healthy replicas.
+func nextReplica() *sql.DB {
+	n := uint64(len(replicas))
+	for i := uint64(0); i < n; i++ {
+		r := replicas[(next.Add(1)-1)%n]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+
+	return nil
+}
+
+// WatchReplicas pings every replica at the configured interval until ctx
+// is done, taking failing ones out of rotation and bringing them back when
+// they answer again. report is told about every change, replicas are
+// numbered from 1 in the order of the config.
+func WatchReplicas(ctx context.Context, report func(num int, err error)) {
+	if len(replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(config.ReplicaCheckInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for i, r := range replicas {
+			pctx, cancel := context.WithTimeout(ctx, config.ReplicaCheckInterval())
+			err := r.db.PingContext(pctx)
+			cancel()
+			if r.healthy.Swap(err == nil) != (err == nil) {
+				report(i+1, err)
+			}
+		}
+	}
+}
+
+// Replicas returns how many replicas there are and how many are healthy.
+func Replicas() (total int, healthy int) {
+	for _, r := range replicas {
+		if r.healthy.Load() {
+			healthy++
+		}
+	}
+
+	return len(replicas), healthy
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// How often a transaction is tried before a deadlock is given back to the
+// caller.
+const txAttempts = 3
+
+// Querier is what *sql.DB and *sql.Tx have in common, so that a query can
+// run either on its own or as part of a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// WithTx runs fn in a transaction, which is committed when fn returns nil
+// and rolled back otherwise. When MySQL picks the transaction as a deadlock
+// victim or a lock wait times out, the whole of fn is run again, so fn must
+// not have side effects outside tx.
+func WithTx(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
+	for attempt := 1; ; attempt++ {
+		err = runTx(ctx, fn)
+		if err == nil || !IsRetryable(err) || attempt == txAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		}
+	}
+}
+
+func runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := Writer(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsRetryable tells deadlocks (1213) and lock wait timeouts (1205), after
+// which the transaction can simply be tried again.
+func IsRetryable(err error) bool {
+	var e *mysql.MySQLError
+	if errors.As(err, &e) {
+		return e.Number == 1213 || e.Number == 1205
+	}
+	return false
+}
+
+// IsDuplicate tells whether err is a duplicate key error (1062).
+func IsDuplicate(err error) bool {
+	var e *mysql.MySQLError
+	return errors.As(err, &e) && e.Number == 1062
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"echo-demo/db/dbtest"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Deadlocks and lock wait timeouts run the whole transaction again, up
+// to txAttempts times, other errors end it right away.
+func TestWithTxRetry(t *testing.T) {
+	saved := dbPool
+	t.Cleanup(func() { dbPool = saved })
+
+	deadlock := &mysql.MySQLError{Number: 1213}
+	lockWait := &mysql.MySQLError{Number: 1205}
+	duplicate := &mysql.MySQLError{Number: 1062}
+	tests := []struct {
+		name string
+		errs []error
+		runs int
+		err  error
+	}{
+		{"ok", nil, 1, nil},
+		{"deadlock", []error{deadlock}, 2, nil},
+		{"lock wait, then deadlock", []error{lockWait, deadlock}, 3, nil},
+		{"deadlocked for good", []error{deadlock, deadlock, deadlock, deadlock}, txAttempts, deadlock},
+		{"duplicate", []error{duplicate, deadlock}, 1, duplicate},
+	}
+	for _, tt := range tests {
+		errs := tt.errs
+		dbPool = (&dbtest.DB{
+			Exec: map[string]func([]driver.Value) (int64, error){
+				"UPDATE counters SET n = n + 1": func([]driver.Value) (int64, error) {
+					if len(errs) == 0 {
+						return 1, nil
+					}
+					err := errs[0]
+					errs = errs[1:]
+					return 0, err
+				},
+			},
+		}).Open(t)
+
+		ctx := context.Background()
+		runs := 0
+		err := WithTx(ctx, func(tx *sql.Tx) error {
+			runs++
+			_, err := tx.ExecContext(ctx, "UPDATE counters SET n = n + 1")
+			return err
+		})
+		if runs != tt.runs || !errors.Is(err, tt.err) {
+			t.Errorf("%s: %d runs, err %v, want %d, %v", tt.name, runs, err, tt.runs, tt.err)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&mysql.MySQLError{Number: 1213}, true},
+		{&mysql.MySQLError{Number: 1205}, true},
+		{&mysql.MySQLError{Number: 1062}, false},
+		{errors.Join(errors.New("insert"), &mysql.MySQLError{Number: 1213}), true},
+		{sql.ErrNoRows, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"echo-demo/audit"
+	"echo-demo/config"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// record writes an audit event for the request, secrets name fields that
+// changed without showing up in before or after. The request has already
+// succeeded or failed on its own, so a failed write is only logged, and a
+// client going away does not cancel it.
+func record(c echo.Context, actorID int64, action string, targetID int64, before any, after any, secrets ...string) {
+	err := audit.Record(context.WithoutCancel(c.Request().Context()), newEvent(c, actorID, action, targetID, before, after, secrets...))
+	if err != nil {
+		log.ErrorContext(c.Request().Context(), "audit record failed", "action", action, "err", err)
+	}
+}
+
+// newEvent takes what an event needs of the request, for those written
+// after it is done with.
+func newEvent(c echo.Context, actorID int64, action string, targetID int64, before any, after any, secrets ...string) *audit.Event {
+	return &audit.Event{
+		ActorID:   actorID,
+		Action:    action,
+		TargetID:  targetID,
+		Diff:      audit.Diff(before, after, secrets...),
+		IP:        c.RealIP(),
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	}
+}
+
+// audited returns the request context with the audit event of the change
+// about to be made, for the users package to write in the transaction of
+// the change, so that neither is kept without the other. after may be
+// audit.Result, to take the user as changed.
+func audited(c echo.Context, actorID int64, action string, before any, after any, secrets ...string) context.Context {
+	return audit.Pending(c.Request().Context(), &audit.Event{
+		ActorID:   actorID,
+		Action:    action,
+		IP:        c.RealIP(),
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	}, before, after, secrets...)
+}
+
+// GetAudit filters by actor_id, action (a trailing dot matches a family,
+// like "user."), target_id, since and until, newest first. before_id pages
+// back from the last event seen.
+func GetAudit(c echo.Context) error {
+	if authID := claims(c).ID; authID != 1 {
+		return UnauthorizedErr("Admin Required")
+	}
+
+	f := &audit.Filter{Action: c.QueryParam("action")}
+	for key, ptr := range map[string]*int64{
+		"actor_id":  &f.ActorID,
+		"target_id": &f.TargetID,
+		"before_id": &f.BeforeID,
+		"limit":     &f.Limit,
+	} {
+		val := c.QueryParam(key)
+		if len(val) == 0 {
+			continue
+		}
+		num, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || num < 0 {
+			return BadRequestErr("%s(%s) Invalid", key, val)
+		}
+		*ptr = num
+	}
+	if f.Limit <= 0 {
+		f.Limit = int64(config.RecordLimit())
+	}
+	if f.Limit > int64(config.RecordMax()) {
+		f.Limit = int64(config.RecordMax())
+	}
+
+	for key, ptr := range map[string]**time.Time{
+		"since": &f.Since,
+		"until": &f.Until,
+	} {
+		val := c.QueryParam(key)
+		if len(val) == 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return BadRequestErr("%s(%s) Invalid", key, val)
+		}
+		*ptr = &t
+	}
+
+	events, err := audit.Query(c.Request().Context(), f)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
+
+func VerifyAudit(c echo.Context) error {
+	if authID := claims(c).ID; authID != 1 {
+		return UnauthorizedErr("Admin Required")
+	}
+
+	brokenID, err := audit.Verify(c.Request().Context())
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &audit.Verification{Intact: brokenID == 0, BrokenID: brokenID})
+}
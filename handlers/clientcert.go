@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"echo-demo/db"
+	"echo-demo/logging"
+	"echo-demo/users"
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// ClientCert signs in services by their client certificate, verified by
+// the TLS handshake already, as the user its common name maps to. Other
+// requests go on to the JWT check.
+func ClientCert(byName map[string]int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			state := c.Request().TLS
+			if state == nil || len(state.VerifiedChains) == 0 {
+				return next(c)
+			}
+			id, ok := byName[state.VerifiedChains[0][0].Subject.CommonName]
+			if !ok {
+				return next(c)
+			}
+
+			uOut, err := users.GetOneByID(c.Request().Context(), id)
+			if errors.Is(err, db.ErrNotFound) {
+				return UnauthorizedErr("Certificate User Unknown")
+			}
+			if err != nil {
+				return err
+			}
+
+			// Certificates count as a second factor.
+			c.Set("user", &jwt.Token{Valid: true, Claims: &JwtCustomClaims{ID: uOut.ID, Name: uOut.Name, TwoFactor: true}})
+			logging.SetUser(c.Request().Context(), uOut.ID)
+			return next(c)
+		}
+	}
+}
+
+// CertAuthenticated tells the JWT check that ClientCert has signed the
+// request in already.
+func CertAuthenticated(c echo.Context) bool {
+	_, ok := c.Get("user").(*jwt.Token)
+	return ok
+}
@@ -1,16 +1,20 @@
 package handlers
 
 import (
-	"fmt"
+	"echo-demo/config"
+	"echo-demo/db"
+	"echo-demo/i18n"
 	"net/http"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
 type JwtCustomClaims struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	TwoFactor bool   `json:"2fa"`
 	jwt.RegisteredClaims
 }
 
@@ -20,16 +24,76 @@ func claims(c echo.Context) *JwtCustomClaims {
 }
 
 func BadRequestErr(format string, a ...any) error {
-	msg := fmt.Sprintf(format, a...)
+	msg := i18n.NewMessage(format, a...)
 	return echo.NewHTTPError(http.StatusBadRequest, msg)
 }
 
 func NotFoundErr(format string, a ...any) error {
-	msg := fmt.Sprintf(format, a...)
+	msg := i18n.NewMessage(format, a...)
 	return echo.NewHTTPError(http.StatusNotFound, msg)
 }
 
 func UnauthorizedErr(format string, a ...any) error {
-	msg := fmt.Sprintf(format, a...)
+	msg := i18n.NewMessage(format, a...)
 	return echo.NewHTTPError(http.StatusUnauthorized, msg)
 }
+
+func ConflictErr(format string, a ...any) error {
+	msg := i18n.NewMessage(format, a...)
+	return echo.NewHTTPError(http.StatusConflict, msg)
+}
+
+func TooManyRequestsErr(format string, a ...any) error {
+	msg := i18n.NewMessage(format, a...)
+	return echo.NewHTTPError(http.StatusTooManyRequests, msg)
+}
+
+func PreconditionFailedErr(format string, a ...any) error {
+	msg := i18n.NewMessage(format, a...)
+	return echo.NewHTTPError(http.StatusPreconditionFailed, msg)
+}
+
+func PreconditionRequiredErr(format string, a ...any) error {
+	msg := i18n.NewMessage(format, a...)
+	return echo.NewHTTPError(http.StatusPreconditionRequired, msg)
+}
+
+// primaryCookie marks a client that wrote lately, so that it reads its
+// writes from the primary until the replicas have caught up.
+const primaryCookie = "read_primary"
+
+// ReadYourWrites lets a request read from replicas until it writes, and
+// from the primary after that. Requests that are meant to write read from
+// the primary from the start, as they usually check what they change. A
+// client that wrote keeps reading from the primary for the replica lag of
+// the config, as long as it sends the cookie it got back.
+func ReadYourWrites(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		primary := req.Method != http.MethodGet && req.Method != http.MethodHead
+		if _, err := req.Cookie(primaryCookie); err == nil {
+			primary = true
+		}
+		ctx := db.Sticky(req.Context(), primary)
+		c.SetRequest(req.WithContext(ctx))
+
+		if lag := config.ReplicaLag(); lag > 0 && len(config.DbReplicas()) > 0 {
+			c.Response().Before(func() {
+				if !db.Wrote(ctx) {
+					return
+				}
+				c.SetCookie(&http.Cookie{
+					Name:     primaryCookie,
+					Value:    "1",
+					Path:     "/",
+					MaxAge:   int(lag / time.Second),
+					Secure:   c.IsTLS(),
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			})
+		}
+
+		return next(c)
+	}
+}
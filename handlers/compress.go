@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+)
+
+// Compress encodes responses with the first of encodings, br or gzip,
+// the client accepts. Bodies shorter than minLength, and types that are
+// compressed already, are sent as they are. Level is that of gzip, 1 to
+// 9, brotli gets the closest of its own 0 to 11.
+func Compress(encodings []string, level, minLength int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res := c.Response()
+			res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+
+			encoding := acceptEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding), encodings)
+			if len(encoding) == 0 || c.Request().Method == http.MethodHead {
+				return next(c)
+			}
+
+			cw := &compressWriter{ResponseWriter: res.Writer, encoding: encoding, level: level, minLength: minLength}
+			res.Writer = cw
+			defer func() { res.Writer = cw.ResponseWriter }()
+
+			// The error is rendered into cw before it is closed, and still
+			// returned for the middleware further out.
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+			if cerr := cw.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}
+	}
+}
+
+// acceptEncoding picks the first of encodings that header allows.
+func acceptEncoding(header string, encodings []string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if key, val, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			if num, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+				q = num
+			}
+		}
+		accepted[name] = q > 0
+	}
+	for _, enc := range encodings {
+		allowed, ok := accepted[enc]
+		if !ok {
+			allowed, ok = accepted["*"]
+		}
+		if ok && allowed {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compressWriter holds the body back until minLength bytes, a flush or the
+// end of the response tell whether it is worth encoding.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding  string
+	level     int
+	minLength int
+
+	status  int
+	buf     []byte
+	started bool
+	enc     io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.started {
+		return w.body().Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minLength {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *compressWriter) Flush() {
+	if !w.started {
+		// Streams are worth it, however little they sent so far.
+		if err := w.start(true); err != nil {
+			return
+		}
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close sends what is held back and ends the encoding.
+func (w *compressWriter) Close() error {
+	if !w.started {
+		if w.status == 0 && len(w.buf) == 0 {
+			return nil
+		}
+		if err := w.start(false); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	header := w.Header()
+	if compress && compressible(w.status, header) {
+		header.Set(echo.HeaderContentEncoding, w.encoding)
+		header.Del(echo.HeaderContentLength)
+		if tag := header.Get("ETag"); len(tag) > 0 {
+			header.Set("ETag", encodedETag(tag, w.encoding))
+		}
+		switch w.encoding {
+		case "br":
+			w.enc = brotli.NewWriterLevel(w.ResponseWriter, brotliLevel(w.level))
+		case "gzip":
+			enc, err := gzip.NewWriterLevel(w.ResponseWriter, w.level)
+			if err != nil {
+				return err
+			}
+			w.enc = enc
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.body().Write(buf)
+	return err
+}
+
+func (w *compressWriter) body() io.Writer {
+	if w.enc != nil {
+		return w.enc
+	}
+	return w.ResponseWriter
+}
+
+// encodedETag suffixes tag with the encoding, since the encoded bytes are
+// not those the tag was made for. The suffix leaves the user version
+// ifMatch reads alone.
+func encodedETag(tag, encoding string) string {
+	if !strings.HasSuffix(tag, `"`) {
+		return tag
+	}
+	return tag[:len(tag)-1] + "-" + encoding + `"`
+}
+
+// compressible leaves out empty answers, bodies encoded by the handler
+// and media that are compressed by their format.
+func compressible(status int, header http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusPartialContent || status == http.StatusNotModified {
+		return false
+	}
+	if len(header.Get(echo.HeaderContentEncoding)) > 0 {
+		return false
+	}
+	ctype, _, _ := strings.Cut(header.Get(echo.HeaderContentType), ";")
+	switch ctype = strings.TrimSpace(strings.ToLower(ctype)); {
+	case strings.HasPrefix(ctype, "image/") && ctype != "image/svg+xml",
+		strings.HasPrefix(ctype, "video/"), strings.HasPrefix(ctype, "audio/"),
+		ctype == "application/zip", ctype == "application/gzip", ctype == "application/pdf":
+		return false
+	}
+	return true
+}
+
+// brotliLevel maps a gzip level onto the brotli scale.
+func brotliLevel(level int) int {
+	switch {
+	case level <= 0:
+		return brotli.DefaultCompression
+	case level >= 9:
+		return brotli.BestCompression
+	}
+	return level * brotli.BestCompression / 9
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAcceptEncoding(t *testing.T) {
+	both := []string{"br", "gzip"}
+	tests := []struct {
+		header    string
+		encodings []string
+		want      string
+	}{
+		{"", both, ""},
+		{"gzip", both, "gzip"},
+		{"gzip, br", both, "br"},
+		{"gzip, br", []string{"gzip", "br"}, "gzip"},
+		{"GZIP", both, "gzip"},
+		{"deflate", both, ""},
+		{"br;q=0, gzip", both, "gzip"},
+		{"br; q=0.0, gzip;q=0", both, ""},
+		{"br;q=0.5", both, "br"},
+		{"*", both, "br"},
+		{"*;q=0", both, ""},
+		{"br;q=0, *", both, "gzip"},
+		{"identity", both, ""},
+		{"identity, *;q=0", both, ""},
+		{"gzip", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := acceptEncoding(tt.header, tt.encodings); got != tt.want {
+			t.Errorf("acceptEncoding(%q, %q) = %q, want %q", tt.header, tt.encodings, got, tt.want)
+		}
+	}
+}
+
+// A body is held back until minLength bytes show whether it is worth
+// encoding, and the end of a short one sends it as it is.
+func TestCompressWriterMinLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		writes  []string
+		flush   bool
+		ctype   string
+		encoded bool
+	}{
+		{"short", []string{"tiny"}, false, "text/plain", false},
+		{"long", []string{strings.Repeat("a", 64)}, false, "text/plain", true},
+		{"long in pieces", []string{strings.Repeat("a", 20), strings.Repeat("b", 20)}, false, "text/plain", true},
+		{"just short", []string{strings.Repeat("a", 31)}, false, "text/plain", false},
+		{"exactly", []string{strings.Repeat("a", 32)}, false, "text/plain", true},
+		{"flushed", []string{"tiny"}, true, "text/plain", true},
+		{"image", []string{strings.Repeat("a", 64)}, false, "image/png", false},
+		{"empty", nil, false, "text/plain", false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		rec.Header().Set(echo.HeaderContentType, tt.ctype)
+		w := &compressWriter{ResponseWriter: rec, encoding: "gzip", level: gzip.DefaultCompression, minLength: 32}
+
+		var want string
+		for _, s := range tt.writes {
+			if _, err := w.Write([]byte(s)); err != nil {
+				t.Fatal(err)
+			}
+			want += s
+			if !w.started && rec.Body.Len() > 0 {
+				t.Errorf("%s: written before the decision", tt.name)
+			}
+		}
+		if tt.flush {
+			w.Flush()
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		encoded := rec.Header().Get(echo.HeaderContentEncoding) == "gzip"
+		if encoded != tt.encoded {
+			t.Errorf("%s: encoded = %v, want %v", tt.name, encoded, tt.encoded)
+			continue
+		}
+		got := rec.Body.String()
+		if encoded {
+			r, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			got = string(b)
+		}
+		if got != want {
+			t.Errorf("%s: body = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+// The encoded body gets a tag of its own, and the handler error still
+// reaches the middleware further out.
+func TestCompress(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = ErrorHandler
+	mw := Compress([]string{"gzip"}, gzip.DefaultCompression, 8)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, "gzip")
+	rec := httptest.NewRecorder()
+	err := mw(func(c echo.Context) error {
+		c.Response().Header().Set("ETag", `"1.2-json"`)
+		return c.String(http.StatusOK, strings.Repeat("a", 64))
+	})(e.NewContext(req, rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Header().Get("ETag"); got != `"1.2-json-gzip"` {
+		t.Errorf("ETag = %s, want %s", got, `"1.2-json-gzip"`)
+	}
+
+	failed := errors.New("failed")
+	rec = httptest.NewRecorder()
+	err = mw(func(c echo.Context) error { return failed })(e.NewContext(req, rec))
+	if err != failed {
+		t.Errorf("err = %v, want %v", err, failed)
+	}
+	if rec.Code != http.StatusInternalServerError || rec.Body.Len() == 0 {
+		t.Errorf("error rendered as %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"echo-demo/config"
+	"echo-demo/logging"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminRequired lets only the admin through, for whole groups of routes.
+func AdminRequired(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if authID := claims(c).ID; authID != 1 {
+			return UnauthorizedErr("Admin Required")
+		}
+		return next(c)
+	}
+}
+
+type RuntimeOutput struct {
+	Goroutines   int           `json:"goroutines"`
+	GOMAXPROCS   int           `json:"gomaxprocs"`
+	NumCPU       int           `json:"num_cpu"`
+	HeapAlloc    uint64        `json:"heap_alloc"`
+	HeapSys      uint64        `json:"heap_sys"`
+	HeapObjects  uint64        `json:"heap_objects"`
+	Sys          uint64        `json:"sys"`
+	NumGC        int64         `json:"num_gc"`
+	LastGC       time.Time     `json:"last_gc"`
+	PauseTotal   time.Duration `json:"pause_total_ns"`
+	PauseRecent  time.Duration `json:"pause_recent_ns"`
+	NextGC       uint64        `json:"next_gc"`
+	GCCPUPercent float64       `json:"gc_cpu_percent"`
+}
+
+func Runtime(c echo.Context) error {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	rOut := &RuntimeOutput{
+		Goroutines:   runtime.NumGoroutine(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumCPU:       runtime.NumCPU(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapSys:      m.HeapSys,
+		HeapObjects:  m.HeapObjects,
+		Sys:          m.Sys,
+		NumGC:        gc.NumGC,
+		LastGC:       gc.LastGC,
+		PauseTotal:   gc.PauseTotal,
+		NextGC:       m.NextGC,
+		GCCPUPercent: m.GCCPUFraction * 100,
+	}
+	if len(gc.Pause) > 0 {
+		rOut.PauseRecent = gc.Pause[0]
+	}
+
+	return c.JSON(http.StatusOK, rOut)
+}
+
+type BuildOutput struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings"`
+	Deps      map[string]string `json:"deps"`
+}
+
+func Build(c echo.Context) error {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return NotFoundErr("Build Info Missing")
+	}
+
+	bOut := &BuildOutput{
+		GoVersion: info.GoVersion,
+		Path:      info.Main.Path,
+		Version:   info.Main.Version,
+		Settings:  map[string]string{},
+		Deps:      map[string]string{},
+	}
+	for _, s := range info.Settings {
+		bOut.Settings[s.Key] = s.Value
+	}
+	for _, d := range info.Deps {
+		if d.Replace != nil {
+			d = d.Replace
+		}
+		bOut.Deps[d.Path] = d.Version
+	}
+
+	return c.JSON(http.StatusOK, bOut)
+}
+
+type LogLevels struct {
+	Default  string            `json:"default"`
+	Packages map[string]string `json:"packages"`
+}
+
+type LogLevelInput struct {
+	// Package is empty for the default level.
+	Package string `json:"package" form:"package"`
+	Level   string `json:"level" form:"level" validate:"required"`
+}
+
+func GetLogLevels(c echo.Context) error {
+	def, pkgs := logging.Levels()
+	return c.JSON(http.StatusOK, &LogLevels{Default: def, Packages: pkgs})
+}
+
+// SetLogLevel changes a level until the next restart.
+func SetLogLevel(c echo.Context) error {
+	lIn := new(LogLevelInput)
+	if err := c.Bind(lIn); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Data Invalid")
+	}
+	if err := c.Validate(lIn); err != nil {
+		return err
+	}
+
+	if err := logging.SetLevel(lIn.Package, lIn.Level); err != nil {
+		return BadRequestErr("%s(%s) Invalid", "Level", lIn.Level)
+	}
+	log.InfoContext(c.Request().Context(), "log level changed", "package", lIn.Package, "level", lIn.Level)
+
+	return GetLogLevels(c)
+}
+
+type CaptureOutput struct {
+	Seconds    int    `json:"seconds"`
+	CPUProfile string `json:"cpu_profile"`
+	Trace      string `json:"trace"`
+}
+
+// One capture at a time, the runtime can only profile once.
+var capturing sync.Mutex
+
+// Capture records a CPU profile and an execution trace for the given
+// number of seconds into the diag directory, and names the files. It
+// stops early when the client goes away.
+func Capture(c echo.Context) error {
+	seconds := 10
+	if val := c.QueryParam("seconds"); len(val) > 0 {
+		num, err := strconv.Atoi(val)
+		if err != nil || num < 1 || num > config.CaptureMaxSeconds() {
+			return BadRequestErr("%s(%s) Invalid", "Seconds", val)
+		}
+		seconds = num
+	}
+
+	if !capturing.TryLock() {
+		return ConflictErr("Capture Running")
+	}
+	defer capturing.Unlock()
+
+	if err := os.MkdirAll(config.DiagDir(), 0o755); err != nil {
+		return err
+	}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	cOut := &CaptureOutput{
+		Seconds:    seconds,
+		CPUProfile: filepath.Join(config.DiagDir(), "cpu-"+stamp+".pprof"),
+		Trace:      filepath.Join(config.DiagDir(), "trace-"+stamp+".out"),
+	}
+
+	cpuFile, err := os.Create(cOut.CPUProfile)
+	if err != nil {
+		return err
+	}
+	defer cpuFile.Close()
+	traceFile, err := os.Create(cOut.Trace)
+	if err != nil {
+		return err
+	}
+	defer traceFile.Close()
+
+	if err := pprof.StartCPUProfile(cpuFile); err != nil {
+		return ConflictErr("Capture Running")
+	}
+	if err := trace.Start(traceFile); err != nil {
+		pprof.StopCPUProfile()
+		return ConflictErr("Capture Running")
+	}
+
+	timer := time.NewTimer(time.Duration(seconds) * time.Second)
+	select {
+	case <-timer.C:
+	case <-c.Request().Context().Done():
+		timer.Stop()
+	}
+	trace.Stop()
+	pprof.StopCPUProfile()
+	log.InfoContext(c.Request().Context(), "capture written", "cpu_profile", cOut.CPUProfile, "trace", cOut.Trace)
+
+	return c.JSON(http.StatusOK, cOut)
+}
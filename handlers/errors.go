@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"echo-demo/config"
+	"echo-demo/db"
+	"echo-demo/i18n"
+	"echo-demo/openapi"
+	"echo-demo/tracing"
+	"echo-demo/users"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+)
+
+// MIMEProblemJSON is the media type of RFC 7807 problem details.
+const MIMEProblemJSON = "application/problem+json"
+
+// StatusClientClosedRequest is the nginx status for a request the client
+// gave up on before the answer was ready.
+const StatusClientClosedRequest = 499
+
+// Problem is an RFC 7807 problem detail. Code is stable and meant for
+// programs, Detail is meant for people and may change.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	TraceID  string       `json:"trace_id,omitempty"`
+	Debug    string       `json:"debug,omitempty"`
+
+	internal error
+}
+
+type FieldError struct {
+	// In is where a parameter came from, path or query, empty for bodies.
+	In      string `json:"in,omitempty"`
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Code, p.Detail)
+}
+
+func (p *Problem) Unwrap() error {
+	return p.internal
+}
+
+// Errors every handler leaves to ErrorHandler.
+var problems = []struct {
+	err    error
+	status int
+	code   string
+	detail string
+}{
+	{db.ErrNotFound, http.StatusNotFound, "not_found", "Not Found"},
+	{db.ErrDupRows, http.StatusConflict, "duplicate", "Already Exists"},
+	{db.ErrVersion, http.StatusPreconditionFailed, "version_mismatch", "Modified"},
+	{users.ErrCursorInvalid, http.StatusBadRequest, "cursor_invalid", "Cursor Invalid"},
+	{context.Canceled, StatusClientClosedRequest, "client_closed_request", "Client Closed Request"},
+	{context.DeadlineExceeded, http.StatusServiceUnavailable, "timeout", "Query Timeout"},
+}
+
+// lang is the language the client asked for with Accept-Language.
+func lang(c echo.Context) language.Tag {
+	return i18n.Match(c.Request().Header.Get("Accept-Language"))
+}
+
+// ErrorHandler renders every error as problem+json, with the detail and
+// field messages in the language of the client. Internal details only
+// show up in debug mode, errors it does not know are plain 500s otherwise.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	tag := lang(c)
+	p := toProblem(err, tag)
+	p.Instance = c.Request().URL.Path
+	p.TraceID = tracing.TraceID(c.Request().Context())
+	if p.Status >= http.StatusInternalServerError && p.Status != http.StatusServiceUnavailable {
+		log.ErrorContext(c.Request().Context(), "request failed", "status", p.Status, "err", err)
+	}
+	if config.Debug() && p.internal != nil {
+		p.Debug = p.internal.Error()
+	}
+	if p.Code == "timeout" {
+		c.Response().Header().Set("Retry-After", "1")
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEProblemJSON)
+		c.Response().Header().Set("Content-Language", tag.String())
+		err = c.JSON(p.Status, p)
+	}
+	if err != nil {
+		log.ErrorContext(c.Request().Context(), "error response failed", "err", err)
+	}
+}
+
+func toProblem(err error, tag language.Tag) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		q := *p
+		return q.fill()
+	}
+
+	var ve validator.ValidationErrors
+	if errors.As(err, &ve) {
+		return (&Problem{
+			Status: http.StatusBadRequest,
+			Code:   "validation_failed",
+			Detail: i18n.Sprintf(tag, "Validation Failed"),
+			Errors: fieldErrors(ve, tag),
+		}).fill()
+	}
+
+	var re *openapi.RequestError
+	if errors.As(err, &re) {
+		return (&Problem{
+			Status: http.StatusBadRequest,
+			Code:   "request_invalid",
+			Detail: i18n.Sprintf(tag, "Request Invalid"),
+			Errors: issueErrors(re.Issues, tag),
+		}).fill()
+	}
+
+	for _, known := range problems {
+		if errors.Is(err, known.err) {
+			return (&Problem{Status: known.status, Code: known.code, Detail: i18n.Sprintf(tag, known.detail), internal: err}).fill()
+		}
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		p := &Problem{Status: he.Code, internal: he.Internal}
+		switch msg := he.Message.(type) {
+		case *i18n.Message:
+			p.Detail = msg.Localize(tag)
+		case string:
+			p.Detail = i18n.Sprintf(tag, msg)
+		case nil:
+		default:
+			p.Detail = fmt.Sprint(msg)
+		}
+		return p.fill()
+	}
+
+	return (&Problem{Status: http.StatusInternalServerError, internal: err}).fill()
+}
+
+// fill derives what is missing from the status.
+func (p *Problem) fill() *Problem {
+	if len(p.Type) == 0 {
+		p.Type = "about:blank"
+	}
+	if len(p.Title) == 0 {
+		p.Title = http.StatusText(p.Status)
+		if p.Status == StatusClientClosedRequest {
+			p.Title = "Client Closed Request"
+		}
+	}
+	if len(p.Code) == 0 {
+		p.Code = statusCode(p.Status)
+	}
+	return p
+}
+
+// statusCode turns a status text like "Not Found" into "not_found".
+func statusCode(status int) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		case r == ' ' || r == '-':
+			return '_'
+		}
+		return -1
+	}, http.StatusText(status))
+}
+
+// fieldErrors names each failed field the way clients send it, with the
+// rule it broke.
+func fieldErrors(ve validator.ValidationErrors, tag language.Tag) []FieldError {
+	errs := make([]FieldError, 0, len(ve))
+	for _, fe := range ve {
+		errs = append(errs, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: ruleMessage(tag, fe.Tag(), fe.Param()),
+		})
+	}
+	return errs
+}
+
+// issueErrors does the same for requests the spec rejects.
+func issueErrors(issues []openapi.Issue, tag language.Tag) []FieldError {
+	errs := make([]FieldError, 0, len(issues))
+	for _, i := range issues {
+		fe := FieldError{Field: i.Name, Rule: i.Rule, Message: ruleMessage(tag, i.Rule, i.Param)}
+		if i.In != "body" {
+			fe.In = i.In
+		}
+		errs = append(errs, fe)
+	}
+	return errs
+}
+
+func ruleMessage(tag language.Tag, rule string, param string) string {
+	switch rule {
+	case "required":
+		return i18n.Sprintf(tag, "is required")
+	case "email":
+		return i18n.Sprintf(tag, "must be a valid email address")
+	case "gte", "min", "minimum":
+		return i18n.Sprintf(tag, "must be at least %s", param)
+	case "lte", "max", "maximum":
+		return i18n.Sprintf(tag, "must be at most %s", param)
+	case "oneof", "enum":
+		return i18n.Sprintf(tag, "must be one of %s", param)
+	case "minLength":
+		return i18n.Sprintf(tag, "must be at least %s characters long", param)
+	case "maxLength":
+		return i18n.Sprintf(tag, "must be at most %s characters long", param)
+	case "type":
+		return i18n.Sprintf(tag, "must be of type %s", param)
+	case "format":
+		return i18n.Sprintf(tag, "must be a valid %s", param)
+	}
+	return i18n.Sprintf(tag, "does not satisfy %s", rule)
+}
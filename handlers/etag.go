@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"echo-demo/config"
+	"echo-demo/db"
+	"echo-demo/users"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// etag names the version of the user in the media type mt, since each
+// representation has its own bytes.
+func etag(uOut *users.Output, mt string) string {
+	return fmt.Sprintf(`"%d.%d-%s"`, uOut.ID, uOut.Version, mt[strings.LastIndex(mt, "/")+1:])
+}
+
+// ifMatch returns the user version an If-Match header asks for, or 0 when
+// any version will do. The version is what counts, not the representation
+// the tag was sent with, so a list may name one version in several.
+func ifMatch(c echo.Context, id int64) (int64, error) {
+	header := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if len(header) == 0 {
+		if config.RequireIfMatch() {
+			return 0, PreconditionRequiredErr("If-Match Required")
+		}
+		return 0, nil
+	}
+	if header == "*" {
+		return 0, nil
+	}
+
+	var version int64
+	for _, t := range strings.Split(header, ",") {
+		v, ok := tagVersion(strings.TrimSpace(t), id)
+		if !ok {
+			continue
+		}
+		if version > 0 && v != version {
+			return 0, db.ErrVersion
+		}
+		version = v
+	}
+	if version == 0 {
+		return 0, db.ErrVersion
+	}
+
+	return version, nil
+}
+
+// tagVersion reads the user version from a strong tag of the user id.
+func tagVersion(tag string, id int64) (int64, bool) {
+	tag, ok := strings.CutPrefix(tag, `"`)
+	tag, closed := strings.CutSuffix(tag, `"`)
+	if !ok || !closed {
+		return 0, false
+	}
+	tag, _, _ = strings.Cut(tag, "-")
+	idStr, versionStr, _ := strings.Cut(tag, ".")
+	tagID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || tagID != id {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// notModified tells whether If-None-Match already names the current
+// representation, as it is or as Compress encoded it.
+func notModified(c echo.Context, tag string) bool {
+	header := c.Request().Header.Get("If-None-Match")
+	if len(header) == 0 {
+		return false
+	}
+
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag || t == encodedETag(tag, "br") || t == encodedETag(tag, "gzip") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withETag answers with the user and its ETag, or 304 if the client has it.
+func withETag(c echo.Context, code int, uOut *users.Output) error {
+	mt, ok := negotiate(c.Request().Header.Get(echo.HeaderAccept))
+	if !ok {
+		// Which is 406.
+		return Render(c, code, uOut)
+	}
+
+	tag := etag(uOut, mt)
+	c.Response().Header().Set("ETag", tag)
+	if code == http.StatusOK && c.Request().Method == http.MethodGet && notModified(c, tag) {
+		c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return Render(c, code, uOut)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"echo-demo/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func withHeader(name, value string) echo.Context {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if len(value) > 0 {
+		req.Header.Set(name, value)
+	}
+	return echo.New().NewContext(req, httptest.NewRecorder())
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int64
+		err     error
+	}{
+		{"", 0, nil},
+		{"*", 0, nil},
+		{` "7.3" `, 3, nil},
+		{`"7.3-json"`, 3, nil},
+		{`"7.3-xml"`, 3, nil},
+		{`"7.3-json-gzip"`, 3, nil},
+		{`W/"7.3-json"`, 0, db.ErrVersion},
+		{`"8.3-json"`, 0, db.ErrVersion},
+		{`"7.0-json"`, 0, db.ErrVersion},
+		{`"7.-1-json"`, 0, db.ErrVersion},
+		{`"7"`, 0, db.ErrVersion},
+		{`7.3`, 0, db.ErrVersion},
+		{`"7.3`, 0, db.ErrVersion},
+		{`"7.3-json", "7.3-xml"`, 3, nil},
+		{`"8.1-json", W/"7.2-json", "7.3-json"`, 3, nil},
+		{`"7.3-json", "7.4-json"`, 0, db.ErrVersion},
+		{`"8.1-json", "9.1-json"`, 0, db.ErrVersion},
+		{`"7.3x-json"`, 0, db.ErrVersion},
+	}
+	for _, tt := range tests {
+		version, err := ifMatch(withHeader("If-Match", tt.header), 7)
+		if version != tt.version || err != tt.err {
+			t.Errorf("%q: version %d, err %v, want %d, %v", tt.header, version, err, tt.version, tt.err)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tag := `"7.3-json"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{`"7.3-json"`, true},
+		{`W/"7.3-json"`, true},
+		{"*", true},
+		{`"7.2-json"`, false},
+		{`"7.3-xml"`, false},
+		{`"7.3"`, false},
+		{`"7.2-json", "7.3-json"`, true},
+		{`"7.2-json",W/"7.3-json"`, true},
+		{`"7.2-json", "7.1-json"`, false},
+		{`"7.3-json-gzip"`, true},
+		{`W/"7.3-json-br"`, true},
+		{`"7.3-json-deflate"`, false},
+		{`"7.3-xml-gzip"`, false},
+	}
+	for _, tt := range tests {
+		if got := notModified(withHeader("If-None-Match", tt.header), tag); got != tt.want {
+			t.Errorf("%q: not modified = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
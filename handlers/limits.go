@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/bytes"
+)
+
+// BodyLimit caps request bodies at limit, or at what routes sets for the
+// matched route, like 32M for an upload. It needs to run after routing.
+func BodyLimit(limit string, routes map[string]string) (echo.MiddlewareFunc, error) {
+	mw := map[string]echo.MiddlewareFunc{}
+	for route, lim := range routes {
+		if _, err := bytes.Parse(lim); err != nil {
+			return nil, fmt.Errorf("BodyLimit(%s=%s) Invalid", route, lim)
+		}
+		mw[route] = middleware.BodyLimit(lim)
+	}
+	if len(limit) > 0 {
+		if _, err := bytes.Parse(limit); err != nil {
+			return nil, fmt.Errorf("BodyLimit(%s) Invalid", limit)
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		limited := next
+		if len(limit) > 0 {
+			limited = middleware.BodyLimit(limit)(next)
+		}
+		routed := map[string]echo.HandlerFunc{}
+		for route, m := range mw {
+			routed[route] = m(next)
+		}
+
+		return func(c echo.Context) error {
+			if h, ok := routed[c.Path()]; ok {
+				return h(c)
+			}
+			return limited(c)
+		}
+	}, nil
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"echo-demo/logging"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	log    = logging.For("handlers")
+	access = logging.For("access")
+)
+
+// RequestID takes the id of a request from X-Request-ID when it looks like
+// one, so that it can be followed across services, and makes one up
+// otherwise. It is sent back and named on every line logged for the
+// request.
+func RequestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		id := req.Header.Get(echo.HeaderXRequestID)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		c.SetRequest(req.WithContext(logging.WithRequest(req.Context(), id)))
+		return next(c)
+	}
+}
+
+// validRequestID keeps ids short and plain, as they end up in logs.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs each request once it is answered, errors included, with
+// secret query parameters redacted. Like the RequestLogger of echo, it has
+// errors rendered to know their status and still returns them.
+func AccessLog(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		req, res := c.Request(), c.Response()
+		level := slog.LevelInfo
+		if res.Status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		access.LogAttrs(req.Context(), level, "request",
+			slog.String("method", req.Method),
+			slog.String("uri", logging.RedactURL(req.URL)),
+			slog.Int("status", res.Status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("ip", c.RealIP()),
+			slog.Int64("bytes_out", res.Size),
+		)
+
+		return err
+	}
+}
+
+// Authenticated names the user of a valid JWT on the log lines of the
+// request.
+func Authenticated(c echo.Context) {
+	logging.SetUser(c.Request().Context(), claims(c).ID)
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"echo-demo/audit"
+	"echo-demo/config"
+	"echo-demo/db"
+	"echo-demo/i18n"
+	"echo-demo/mailer"
+	"echo-demo/password"
+	"echo-demo/users"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+// checkPassword reports every broken password rule, so that clients can
+// show them all at once.
+func checkPassword(c echo.Context, name string, pw string) error {
+	err := password.Check(name, pw)
+	if err == nil {
+		return nil
+	}
+
+	log.DebugContext(c.Request().Context(), "request failed", "err", err)
+	if pe, ok := err.(*password.PolicyError); ok {
+		tag := lang(c)
+		p := &Problem{
+			Status: http.StatusBadRequest,
+			Code:   "password_policy",
+			Detail: i18n.Sprintf(tag, "Password Policy Violated"),
+		}
+		for _, v := range pe.Violations {
+			p.Errors = append(p.Errors, FieldError{Field: "password", Rule: v.Rule, Message: i18n.Sprintf(tag, v.Format, v.Args...)})
+		}
+		return p
+	}
+	return err
+}
+
+// ForgotPassword always answers 202, so that it can not be used to find
+// out which email addresses are registered. For the same reason the work
+// a known address takes on top is done after answering. Only a verified
+// address gets the mail, since anyone could have typed in the others.
+func ForgotPassword(c echo.Context) error {
+	fIn := new(users.ForgotInput)
+	if err := c.Bind(fIn); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Data Invalid")
+	}
+	if err := c.Validate(fIn); err != nil {
+		return err
+	}
+
+	uOut, err := users.GetOneByEmail(c.Request().Context(), fIn.Email)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		if err == db.ErrNotFound {
+			return c.NoContent(http.StatusAccepted)
+		}
+		return err
+	}
+	if !uOut.EmailVerified {
+		return c.NoContent(http.StatusAccepted)
+	}
+
+	go sendReset(context.WithoutCancel(c.Request().Context()), uOut, newEvent(c, 0, "user.password_forgot", uOut.ID, nil, nil))
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// sendReset mails a reset token to the user, with nobody left to tell
+// when it fails but the log.
+func sendReset(ctx context.Context, uOut *users.Output, e *audit.Event) {
+	token, err := users.NewToken(ctx, uOut.ID, users.PurposeReset, "", config.ResetTokenTTL())
+	if err != nil {
+		log.ErrorContext(ctx, "reset token failed", "err", err)
+		return
+	}
+
+	if err := audit.Record(ctx, e); err != nil {
+		log.ErrorContext(ctx, "audit record failed", "action", e.Action, "err", err)
+	}
+
+	err = mailer.Send(&mailer.Message{
+		To:      uOut.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\r\n\r\nUse the token below with POST %s/v1/password/reset within %s to set a new password:\r\n\r\n%s\r\n\r\nIf you did not ask for it, just ignore this mail.",
+			uOut.Name, config.BaseURL(), config.ResetTokenTTL(), token),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "reset mail failed", "err", err)
+	}
+}
+
+func ResetPassword(c echo.Context) error {
+	rIn := new(users.ResetInput)
+	if err := c.Bind(rIn); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Data Invalid")
+	}
+	if err := c.Validate(rIn); err != nil {
+		return err
+	}
+
+	uOut, err := users.TokenOwner(c.Request().Context(), rIn.Token, users.PurposeReset)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		if err == users.ErrTokenInvalid || err == db.ErrNotFound {
+			return BadRequestErr("Token Invalid")
+		}
+		return err
+	}
+	if err := checkPassword(c, uOut.Name, rIn.Password); err != nil {
+		return err
+	}
+
+	ctx := audited(c, uOut.ID, "user.password_reset", nil, nil, "password")
+	if _, err := users.ResetPassword(ctx, rIn.Token, rIn.Password); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		if err == users.ErrTokenInvalid {
+			return BadRequestErr("Token Invalid")
+		}
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func VerifyEmail(c echo.Context) error {
+	vIn := new(users.VerifyInput)
+	if err := c.Bind(vIn); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Data Invalid")
+	}
+	if err := c.Validate(vIn); err != nil {
+		return err
+	}
+
+	uOut, err := users.TokenOwner(c.Request().Context(), vIn.Token, users.PurposeVerify)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		if err == users.ErrTokenInvalid || err == db.ErrNotFound {
+			return BadRequestErr("Token Invalid")
+		}
+		return err
+	}
+
+	ctx := audited(c, uOut.ID, "user.email_verify", map[string]bool{"email_verified": false}, map[string]bool{"email_verified": true})
+	uOut, err = users.VerifyEmail(ctx, vIn.Token)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		if err == users.ErrTokenInvalid {
+			return BadRequestErr("Token Invalid")
+		}
+		return err
+	}
+
+	return Render(c, http.StatusOK, uOut)
+}
+
+func ResendVerification(c echo.Context) error {
+	uOut, err := users.GetOneByID(c.Request().Context(), claims(c).ID)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return err
+	}
+	if len(uOut.Email) == 0 {
+		return BadRequestErr("Email Missing")
+	}
+	if uOut.EmailVerified {
+		return BadRequestErr("Email Already Verified")
+	}
+
+	if err := sendVerification(c.Request().Context(), uOut); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// sendVerification mails a verify token for the current email address of
+// the user, if there is one that is not verified yet.
+func sendVerification(ctx context.Context, uOut *users.Output) error {
+	if len(uOut.Email) == 0 || uOut.EmailVerified {
+		return nil
+	}
+
+	token, err := users.NewToken(ctx, uOut.ID, users.PurposeVerify, uOut.Email, config.VerifyTokenTTL())
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(&mailer.Message{
+		To:      uOut.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\r\n\r\nPOST the token below to %s/v1/email/verify within %s to verify this address:\r\n\r\n%s\r\n\r\nor open %s/v1/email/verify?token=%s",
+			uOut.Name, config.BaseURL(), config.VerifyTokenTTL(), token, config.BaseURL(), url.QueryEscape(token)),
+	})
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	MIMEApplicationMsgpack = "application/msgpack"
+	MIMEApplicationCBOR    = "application/cbor"
+)
+
+// RenderTypes are the media types Render answers with, JSON unless the
+// client asks for another.
+var RenderTypes = []string{echo.MIMEApplicationJSON, echo.MIMEApplicationXML, MIMEApplicationMsgpack, MIMEApplicationCBOR}
+
+// MediaAliases are other names clients use for the same thing.
+var MediaAliases = map[string]string{
+	echo.MIMETextXML:          echo.MIMEApplicationXML,
+	"application/x-msgpack":   MIMEApplicationMsgpack,
+	"application/vnd.msgpack": MIMEApplicationMsgpack,
+}
+
+// MessagePack and CBOR name fields by their json tags, so that every
+// encoding but XML looks the same.
+var cborMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+// Render answers with v in the media type the Accept header prefers among
+// RenderTypes, or 406 when it accepts none of them.
+func Render(c echo.Context, code int, v any) error {
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+
+	mt, ok := negotiate(c.Request().Header.Get(echo.HeaderAccept))
+	if !ok {
+		return echo.ErrNotAcceptable
+	}
+
+	switch mt {
+	case echo.MIMEApplicationXML:
+		return c.XML(code, v)
+	case MIMEApplicationMsgpack:
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return c.Blob(code, mt, buf.Bytes())
+	case MIMEApplicationCBOR:
+		data, err := cborMode.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return c.Blob(code, mt, data)
+	}
+
+	return c.JSON(code, v)
+}
+
+type accepted struct {
+	mt string
+	q  float64
+}
+
+// negotiate picks the render type accept prefers. Ranges like */* fall to
+// the first render type not excluded with q=0.
+func negotiate(accept string) (string, bool) {
+	if len(strings.TrimSpace(accept)) == 0 {
+		return RenderTypes[0], true
+	}
+
+	var ranges []accepted
+	excluded := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		if alias, ok := MediaAliases[mt]; ok {
+			mt = alias
+		}
+		q := 1.0
+		if val, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(val, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			excluded[mt] = true
+			continue
+		}
+		ranges = append(ranges, accepted{mt, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		switch {
+		case r.mt == "*/*" || r.mt == "application/*":
+			for _, mt := range RenderTypes {
+				if !excluded[mt] {
+					return mt, true
+				}
+			}
+		case slices.Contains(RenderTypes, r.mt):
+			return r.mt, true
+		}
+	}
+
+	return "", false
+}
+
+// Binder adds MessagePack and CBOR bodies to what Echo binds.
+type Binder struct {
+	echo.DefaultBinder
+}
+
+func (b *Binder) Bind(i any, c echo.Context) error {
+	mt, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if alias, ok := MediaAliases[mt]; ok {
+		mt = alias
+	}
+	if mt != MIMEApplicationMsgpack && mt != MIMEApplicationCBOR {
+		return b.DefaultBinder.Bind(i, c)
+	}
+
+	if err := b.BindPathParams(c, i); err != nil {
+		return err
+	}
+	if c.Request().ContentLength == 0 {
+		return nil
+	}
+
+	var err error
+	if mt == MIMEApplicationCBOR {
+		err = cbor.NewDecoder(c.Request().Body).Decode(i)
+	} else {
+		dec := msgpack.NewDecoder(c.Request().Body)
+		dec.SetCustomStructTag("json")
+		err = dec.Decode(i)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Data Invalid").SetInternal(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		mt     string
+		ok     bool
+	}{
+		{"", "application/json", true},
+		{"  ", "application/json", true},
+		{"application/json", "application/json", true},
+		{"application/xml", "application/xml", true},
+		{"application/msgpack", "application/msgpack", true},
+		{"application/cbor", "application/cbor", true},
+		{"application/json; charset=UTF-8", "application/json", true},
+		{"APPLICATION/XML", "application/xml", true},
+
+		{"text/xml", "application/xml", true},
+		{"application/x-msgpack", "application/msgpack", true},
+		{"application/vnd.msgpack", "application/msgpack", true},
+		{"text/xml;q=0, application/json", "application/json", true},
+
+		{"application/json;q=0.5, application/xml", "application/xml", true},
+		{"application/xml;q=0.9, application/cbor;q=0.95", "application/cbor", true},
+		{"application/xml, application/json", "application/xml", true},
+		{"application/xml;q=0.5, application/json;q=0.5", "application/xml", true},
+		{"application/xml;q=1.0, text/html", "application/xml", true},
+		{"application/xml;q=abc, application/cbor;q=0.1", "application/cbor", true},
+
+		{"*/*", "application/json", true},
+		{"application/*", "application/json", true},
+		{"*/*;q=0.1, application/xml", "application/xml", true},
+		{"application/json;q=0, */*", "application/xml", true},
+		{"application/json;q=0, application/xml;q=0, application/*", "application/msgpack", true},
+		{"text/html, */*;q=0.8", "application/json", true},
+
+		{"text/html", "", false},
+		{"text/*", "", false},
+		{"application/json;q=0", "", false},
+		{"application/json;q=0, application/xml;q=0, application/msgpack;q=0, application/cbor;q=0, */*", "", false},
+		{"not a type", "", false},
+	}
+	for _, tt := range tests {
+		mt, ok := negotiate(tt.accept)
+		if mt != tt.mt || ok != tt.ok {
+			t.Errorf("negotiate(%q) = %q, %v, want %q, %v", tt.accept, mt, ok, tt.mt, tt.ok)
+		}
+	}
+}
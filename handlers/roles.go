@@ -1,8 +1,9 @@
 package handlers
 
 import (
-	"echo-demo/config"
+	"echo-demo/audit"
 	"echo-demo/db"
+	"echo-demo/logging"
 	"echo-demo/users"
 	"net/http"
 	"strconv"
@@ -16,23 +17,33 @@ import (
 func Login(c echo.Context) error {
 	aIn := new(users.AuthInput)
 	if err := c.Bind(aIn); err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return BadRequestErr("Data Invalid")
 	}
 	if err := c.Validate(aIn); err != nil {
-		c.Echo().Logger.Debug(err)
-		return BadRequestErr("Validation Faild")
+		return err
 	}
 
-	uOut, err := users.Auth(aIn.Name, aIn.Password)
+	uOut, err := users.Auth(c.Request().Context(), aIn.Name, aIn.Password)
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		if err == db.ErrNotFound {
+			record(c, 0, "session.failed", 0, nil, map[string]string{"name": aIn.Name})
 			return UnauthorizedErr("Name|Password Incorrect")
 		}
 		return err
 	}
 
+	if uOut.TwoFactor {
+		record(c, uOut.ID, "session.challenge", uOut.ID, nil, nil)
+		return challenge(c, uOut.ID)
+	}
+
+	record(c, uOut.ID, "session.login", uOut.ID, nil, nil)
+	return loginSession(c, uOut, false)
+}
+
+func loginSession(c echo.Context, uOut *users.Output, twoFactor bool) error {
 	sess, err := session.Get("session", c)
 	if err != nil {
 		return err
@@ -43,11 +54,12 @@ func Login(c echo.Context) error {
 		HttpOnly: true,
 	}
 	sess.Values["role_id"] = uOut.ID
+	sess.Values["2fa"] = twoFactor
 	if err := sess.Save(c.Request(), c.Response()); err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, uOut)
+	return Render(c, http.StatusOK, uOut)
 }
 
 func CreateRole(c echo.Context) error {
@@ -61,24 +73,28 @@ func CreateRole(c echo.Context) error {
 
 	uIn := new(users.Input)
 	if err := c.Bind(uIn); err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return BadRequestErr("Data Invalid")
 	}
 	if err := c.Validate(uIn); err != nil {
-		c.Echo().Logger.Debug(err)
-		return BadRequestErr("Validation Faild")
+		return err
+	}
+	if err := checkPassword(c, uIn.Name, uIn.Password); err != nil {
+		return err
 	}
 
-	uOut, err := users.NewOne(uIn.Name, uIn.Password, uIn.Age, time.Now())
+	ctx := audited(c, logID, "user.create", nil, audit.Result)
+	uOut, err := users.NewOne(ctx, uIn.Name, uIn.Password, uIn.Age, uIn.Email, time.Now())
 	if err != nil {
-		c.Echo().Logger.Debug(err)
-		if err == db.ErrDupRows {
-			return BadRequestErr("User(%s) Duplicate", uIn.Name)
-		}
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return err
 	}
 
-	return c.JSON(http.StatusCreated, uOut)
+	if err := sendVerification(c.Request().Context(), uOut); err != nil {
+		log.ErrorContext(c.Request().Context(), "verification mail failed", "err", err)
+	}
+
+	return withETag(c, http.StatusCreated, uOut)
 }
 
 func GetOneRole(c echo.Context) error {
@@ -88,43 +104,26 @@ func GetOneRole(c echo.Context) error {
 
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return BadRequestErr("Id(%s) Invalid", c.Param("id"))
 	}
 
-	uOut, err := users.GetOneByID(int64(id))
+	uOut, err := users.GetOneByID(c.Request().Context(), int64(id))
 	if err != nil {
-		c.Echo().Logger.Debug(err)
-		if err == db.ErrNotFound {
-			return NotFoundErr("User(id:%d) Not Found", id)
-		}
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return err
 	}
 
-	return c.JSON(http.StatusOK, uOut)
+	return withETag(c, http.StatusOK, uOut)
 }
 
 func GetAllRoles(c echo.Context) error {
-	if _, err := loginID(c); err != nil {
-		return err
-	}
-
-	limit, err := strconv.Atoi(c.QueryParam("limit"))
-	if err != nil || limit <= 0 {
-		limit = config.RecordLimit()
-	}
-	offset, err := strconv.Atoi(c.QueryParam("offset"))
-	if err != nil || offset < 0 {
-		offset = config.RecordOffset()
-	}
-
-	uOuts, err := users.GetAll(int64(limit), int64(offset))
+	logID, err := loginID(c)
 	if err != nil {
-		c.Echo().Logger.Debug(err)
 		return err
 	}
 
-	return c.JSON(http.StatusOK, uOuts)
+	return listUsers(c, logID)
 }
 
 func UpdateRole(c echo.Context) error {
@@ -133,38 +132,7 @@ func UpdateRole(c echo.Context) error {
 		return err
 	}
 
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.Echo().Logger.Debug(err)
-		return BadRequestErr("Id(%s) Invalid", c.Param("id"))
-	}
-
-	if logID != 1 && logID != int64(id) {
-		return UnauthorizedErr("Admin or User(id:%d) Required", id)
-	}
-
-	uIn := new(users.Input)
-	if err := c.Bind(uIn); err != nil {
-		c.Echo().Logger.Debug(err)
-		return BadRequestErr("Data Invalid")
-	}
-	if err := c.Validate(uIn); err != nil {
-		c.Echo().Logger.Debug(err)
-		return BadRequestErr("Validation Faild")
-	}
-
-	uOut, err := users.UpdateOne(int64(id), uIn.Name, uIn.Password, uIn.Age)
-	if err != nil {
-		c.Echo().Logger.Debug(err)
-		if err == db.ErrNotFound {
-			return NotFoundErr("User(id:%d) Not Found", id)
-		} else if err == db.ErrDupRows {
-			return BadRequestErr("User(%s) Duplicate", uIn.Name)
-		}
-		return err
-	}
-
-	return c.JSON(http.StatusOK, uOut)
+	return updateUser(c, logID)
 }
 
 func DeleteRole(c echo.Context) error {
@@ -176,22 +144,7 @@ func DeleteRole(c echo.Context) error {
 		return UnauthorizedErr("Admin Required")
 	}
 
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.Echo().Logger.Debug(err)
-		return BadRequestErr("Id(%s) Invalid", c.Param("id"))
-	}
-
-	err = users.DeleteOne(int64(id))
-	if err != nil {
-		c.Echo().Logger.Debug(err)
-		if err == db.ErrNotFound {
-			return NotFoundErr("User(id:%d) Not Found", id)
-		}
-		return err
-	}
-
-	return c.NoContent(http.StatusNoContent)
+	return deleteUser(c, logID)
 }
 
 func loginID(c echo.Context) (int64, error) {
@@ -210,5 +163,10 @@ func loginID(c echo.Context) (int64, error) {
 		return 0, BadRequestErr("Session Invalid")
 	}
 
+	if twoFactor, _ := sess.Values["2fa"].(bool); !twoFactor && forced2FA(id) {
+		return 0, UnauthorizedErr("2FA Required")
+	}
+	logging.SetUser(c.Request().Context(), id)
+
 	return id, nil
 }
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"echo-demo/logging"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/language"
+)
+
+var tracer = otel.Tracer("echo-demo/handlers")
+
+// Trace continues the trace of a W3C traceparent header, or starts one,
+// with a span for the request named after its route.
+func Trace(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		name := req.Method
+		if route := c.Path(); len(route) > 0 {
+			name += " " + route
+		}
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.HTTPRoute(c.Path()),
+			semconv.URLPath(req.URL.Path),
+			semconv.ClientAddress(c.RealIP()),
+			attribute.String("request_id", logging.RequestID(ctx)),
+		))
+		defer span.End()
+		c.SetRequest(req.WithContext(ctx))
+
+		err := next(c)
+		status := c.Response().Status
+		if err != nil {
+			span.RecordError(err)
+			// Not answered yet, ErrorHandler will when the error is back.
+			if !c.Response().Committed {
+				status = toProblem(err, language.Und).Status
+			}
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		return err
+	}
+}
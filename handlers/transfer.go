@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"echo-demo/audit"
+	"echo-demo/users"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+)
+
+// Import formats, by name and by content type.
+var importFormats = map[string]string{
+	"csv":                  "csv",
+	"text/csv":             "csv",
+	"ndjson":               "ndjson",
+	"jsonl":                "ndjson",
+	"application/x-ndjson": "ndjson",
+	"application/jsonl":    "ndjson",
+	"xml":                  "xml",
+	"application/xml":      "xml",
+	"text/xml":             "xml",
+}
+
+// ImportUsers takes CSV, NDJSON or XML, picked by the format parameter or
+// the content type. mode=best_effort keeps the good rows when others fail,
+// the default is all or nothing. dry_run=true reports without keeping.
+func ImportUsers(c echo.Context) error {
+	authID := claims(c).ID
+	if authID != 1 {
+		return UnauthorizedErr("Admin Required")
+	}
+
+	format := c.QueryParam("format")
+	if len(format) == 0 {
+		format, _, _ = strings.Cut(c.Request().Header.Get(echo.HeaderContentType), ";")
+	}
+	format, ok := importFormats[strings.ToLower(strings.TrimSpace(format))]
+	if !ok {
+		return echo.ErrUnsupportedMediaType
+	}
+
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+	var atomic bool
+	switch c.QueryParam("mode") {
+	case "", "transaction":
+		atomic = true
+	case "best_effort":
+		atomic = false
+	default:
+		return BadRequestErr("Mode(%s) Invalid", c.QueryParam("mode"))
+	}
+
+	var parsed []*users.ImportRow
+	var results []*users.ImportResult
+	add := func(row int, uIn *users.Input, err error) {
+		if err == nil {
+			err = c.Validate(uIn)
+		}
+		if err == nil {
+			err = checkPassword(c, uIn.Name, uIn.Password)
+		}
+		if err != nil {
+			name := ""
+			if uIn != nil {
+				name = uIn.Name
+			}
+			results = append(results, &users.ImportResult{Row: row, Name: name, Error: rowError(c, err)})
+			return
+		}
+		parsed = append(parsed, &users.ImportRow{Row: row, Input: uIn})
+	}
+
+	var err error
+	switch format {
+	case "csv":
+		err = readCSV(c.Request().Body, add)
+	case "ndjson":
+		err = readNDJSON(c.Request().Body, add)
+	case "xml":
+		err = readXML(c.Request().Body, add)
+	}
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Data Invalid")
+	}
+
+	iOut := &users.ImportOutput{DryRun: dryRun, Atomic: atomic}
+	if len(parsed) > 0 {
+		// A bad row already dooms an atomic import, but the database still
+		// gets to report on the rest.
+		dbResults, committed, err := users.Import(audited(c, authID, "user.import", nil, audit.Result), parsed, atomic, dryRun || (atomic && len(results) > 0))
+		if err != nil {
+			log.DebugContext(c.Request().Context(), "request failed", "err", err)
+			return err
+		}
+		iOut.Committed = committed
+		results = append(results, dbResults...)
+
+		if committed {
+			for i, res := range dbResults {
+				if res.ID == 0 {
+					continue
+				}
+				uOut := &users.Output{ID: res.ID, Name: res.Name, Email: parsed[i].Input.Email}
+				if err := sendVerification(c.Request().Context(), uOut); err != nil {
+					log.ErrorContext(c.Request().Context(), "verification mail failed", "err", err)
+				}
+			}
+		}
+	}
+
+	for _, res := range results {
+		if len(res.Error) > 0 {
+			iOut.Failed++
+		} else if iOut.Committed {
+			iOut.Created++
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Row < results[j].Row })
+	iOut.Rows = results
+
+	code := http.StatusOK
+	if iOut.Committed {
+		code = http.StatusCreated
+	}
+	return Render(c, code, iOut)
+}
+
+func rowError(c echo.Context, err error) string {
+	var p *Problem
+	var ve validator.ValidationErrors
+	if !errors.As(err, &p) && !errors.As(err, &ve) {
+		return err.Error()
+	}
+
+	p = toProblem(err, lang(c))
+	msgs := make([]string, 0, len(p.Errors))
+	for _, fe := range p.Errors {
+		msgs = append(msgs, fe.Field+" "+fe.Message)
+	}
+	return p.Detail + ": " + strings.Join(msgs, ", ")
+}
+
+// readCSV wants a header line naming the columns, in any order.
+func readCSV(r io.Reader, add func(int, *users.Input, error)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["name"]; !ok {
+		return errors.New("CSV: name column missing")
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+
+	for row := 1; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				add(row, nil, err)
+				continue
+			}
+			return err
+		}
+
+		uIn := &users.Input{
+			Name:     field(rec, "name"),
+			Password: field(rec, "password"),
+			Email:    field(rec, "email"),
+		}
+		if age := field(rec, "age"); len(age) > 0 {
+			if uIn.Age, err = strconv.ParseInt(age, 10, 64); err != nil {
+				add(row, uIn, fmt.Errorf("Age(%s) Invalid", age))
+				continue
+			}
+		}
+		add(row, uIn, nil)
+	}
+}
+
+func readNDJSON(r io.Reader, add func(int, *users.Input, error)) error {
+	br := bufio.NewReader(r)
+	for row := 1; ; row++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			uIn := new(users.Input)
+			add(row, uIn, json.Unmarshal(line, uIn))
+		} else {
+			row--
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// readXML takes every <user> element, whatever the root element is called.
+func readXML(r io.Reader, add func(int, *users.Input, error)) error {
+	dec := xml.NewDecoder(r)
+	for row := 1; ; {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "user" {
+			continue
+		}
+		uIn := new(users.Input)
+		add(row, uIn, dec.DecodeElement(uIn, &se))
+		row++
+	}
+}
+
+// ExportUsers streams every user as CSV, NDJSON or XML, flushing as it goes.
+func ExportUsers(c echo.Context) error {
+	if authID := claims(c).ID; authID != 1 {
+		return UnauthorizedErr("Admin Required")
+	}
+
+	format := c.QueryParam("format")
+	if len(format) == 0 {
+		format = "ndjson"
+	}
+	format, ok := importFormats[strings.ToLower(format)]
+	if !ok {
+		return BadRequestErr("Format(%s) Invalid", c.QueryParam("format"))
+	}
+
+	ctypes := map[string]string{
+		"csv":    "text/csv; charset=UTF-8",
+		"ndjson": "application/x-ndjson",
+		"xml":    echo.MIMEApplicationXMLCharsetUTF8,
+	}
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, ctypes[format])
+	resp.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="users.%s"`, format))
+	resp.WriteHeader(http.StatusOK)
+
+	var err error
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(resp)
+		if err := cw.Write([]string{"id", "name", "age", "email", "email_verified", "reg_date"}); err != nil {
+			return err
+		}
+		err = users.Each(c.Request().Context(), func(uOut *users.Output) error {
+			err := cw.Write([]string{
+				strconv.FormatInt(uOut.ID, 10),
+				uOut.Name,
+				strconv.FormatInt(uOut.Age, 10),
+				uOut.Email,
+				strconv.FormatBool(uOut.EmailVerified),
+				uOut.RegDate.Format(time.RFC3339),
+			})
+			cw.Flush()
+			resp.Flush()
+			return err
+		})
+	case "ndjson":
+		enc := json.NewEncoder(resp)
+		err = users.Each(c.Request().Context(), func(uOut *users.Output) error {
+			err := enc.Encode(uOut)
+			resp.Flush()
+			return err
+		})
+	case "xml":
+		if _, err := io.WriteString(resp, xml.Header+"<users>\n"); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(resp)
+		user := xml.StartElement{Name: xml.Name{Local: "user"}}
+		err = users.Each(c.Request().Context(), func(uOut *users.Output) error {
+			if err := enc.EncodeElement(uOut, user); err != nil {
+				return err
+			}
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+			_, err := io.WriteString(resp, "\n")
+			resp.Flush()
+			return err
+		})
+		if err == nil {
+			_, err = io.WriteString(resp, "</users>\n")
+		}
+	}
+
+	// The status is gone already, all that is left is to log and stop.
+	if err != nil {
+		log.ErrorContext(c.Request().Context(), "export failed", "err", err)
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"echo-demo/config"
+	"echo-demo/db"
+	"echo-demo/users"
+	"echo-demo/vk"
+	"encoding/base64"
+	"encoding/hex"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/valkey-io/valkey-go"
+)
+
+const challengePurpose = "2fa"
+
+type challengeClaims struct {
+	ID      int64  `json:"id"`
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// Challenges are signed with a key of their own, so that they can never
+// pass as an access token on the JWT protected routes.
+func challengeKey(key []byte) []byte {
+	return append(append([]byte{}, key...), "#"+challengePurpose...)
+}
+
+// Each challenge counts the codes sent for it under its jti, and each user
+// the wrong ones, for as long as a challenge lives.
+func challengeAttemptsKey(jti string) string {
+	return "2fa:challenge:" + jti
+}
+
+func challengeFailuresKey(id int64) string {
+	return "2fa:failures:" + strconv.FormatInt(id, 10)
+}
+
+// countAttempt counts one more code for a challenge that is still open,
+// and returns -1 for one that is not.
+var countAttempt = valkey.NewLuaScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+  return -1
+end
+return redis.call("INCR", KEYS[1])
+`)
+
+// newChallenge signs a challenge for the user, with a jti of its own.
+func newChallenge(id int64, ttl time.Duration) (claims *challengeClaims, tokenStr string, err error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, "", err
+	}
+	claims = &challengeClaims{
+		id,
+		challengePurpose,
+		jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	tokenStr, err = token.SignedString(challengeKey(config.SignKey()))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return claims, tokenStr, nil
+}
+
+// parseChallenge checks the signature, expiry and purpose of a challenge.
+// Whether it is still open is up to Valkey.
+func parseChallenge(tokenStr string) (*challengeClaims, error) {
+	claims := new(challengeClaims)
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (any, error) {
+		return challengeKey(config.VerifyKey()), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != challengePurpose || len(claims.RegisteredClaims.ID) == 0 {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	return claims, nil
+}
+
+// challenge answers the password step of a login by a 2FA user with a
+// short-lived token, to be exchanged together with a code.
+func challenge(c echo.Context, id int64) error {
+	ctx := c.Request().Context()
+	ttl := config.ChallengeTTL()
+
+	claims, tokenStr, err := newChallenge(id, ttl)
+	if err != nil {
+		return err
+	}
+
+	client := vk.Client()
+	if err := client.Do(ctx, client.B().Set().Key(challengeAttemptsKey(claims.RegisteredClaims.ID)).Value("0").Ex(ttl).Build()).Error(); err != nil {
+		return err
+	}
+
+	return Render(c, http.StatusAccepted, users.ChallengeOutput{Challenge: tokenStr, ExpiresIn: int64(ttl.Seconds())})
+}
+
+// verifyChallenge checks a challenge token and its code, and returns the
+// user who passed both login steps. A challenge is good for one login and
+// a few codes, a user for a few wrong codes per challenge lifetime, then
+// the password is asked for again.
+func verifyChallenge(c echo.Context) (*users.Output, error) {
+	cIn := new(users.ChallengeInput)
+	if err := c.Bind(cIn); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return nil, BadRequestErr("Data Invalid")
+	}
+	if err := c.Validate(cIn); err != nil {
+		return nil, err
+	}
+
+	claims, err := parseChallenge(cIn.Challenge)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return nil, UnauthorizedErr("Challenge Invalid")
+	}
+
+	ctx := c.Request().Context()
+	client := vk.Client()
+	maxAttempts := int64(config.ChallengeMaxAttempts())
+	attemptsKey, failuresKey := challengeAttemptsKey(claims.RegisteredClaims.ID), challengeFailuresKey(claims.ID)
+
+	failures, err := client.Do(ctx, client.B().Get().Key(failuresKey).Build()).AsInt64()
+	if err != nil && !valkey.IsValkeyNil(err) {
+		return nil, err
+	}
+	if failures >= maxAttempts {
+		client.Do(ctx, client.B().Del().Key(attemptsKey).Build())
+		return nil, TooManyRequestsErr("Too Many Attempts")
+	}
+
+	attempts, err := countAttempt.Exec(ctx, client, []string{attemptsKey}, nil).AsInt64()
+	if err != nil {
+		return nil, err
+	}
+	if attempts < 0 {
+		return nil, UnauthorizedErr("Challenge Invalid")
+	}
+	if attempts > maxAttempts {
+		client.Do(ctx, client.B().Del().Key(attemptsKey).Build())
+		return nil, TooManyRequestsErr("Too Many Attempts")
+	}
+
+	if err := users.VerifyTOTP(ctx, claims.ID, cIn.Code); err != nil {
+		log.DebugContext(ctx, "request failed", "err", err)
+		if err == users.ErrCodeInvalid || err == users.ErrTOTPNotEnrolled || err == db.ErrNotFound {
+			record(c, claims.ID, "2fa.failed", claims.ID, nil, nil)
+			if attempts == maxAttempts {
+				client.Do(ctx, client.B().Del().Key(attemptsKey).Build())
+			}
+			failed := client.DoMulti(ctx,
+				client.B().Incr().Key(failuresKey).Build(),
+				client.B().Expire().Key(failuresKey).Seconds(int64(config.ChallengeTTL().Seconds())).Nx().Build())
+			for _, resp := range failed {
+				if err := resp.Error(); err != nil {
+					return nil, err
+				}
+			}
+			return nil, UnauthorizedErr("Code Incorrect")
+		}
+		return nil, err
+	}
+
+	// Burnt, whoever gets here first logs in.
+	burnt, err := client.Do(ctx, client.B().Del().Key(attemptsKey).Build()).AsInt64()
+	if err != nil {
+		return nil, err
+	}
+	if burnt == 0 {
+		return nil, UnauthorizedErr("Challenge Invalid")
+	}
+	client.Do(ctx, client.B().Del().Key(failuresKey).Build())
+
+	return users.GetOneByID(ctx, claims.ID)
+}
+
+func Auth2FA(c echo.Context) error {
+	uOut, err := verifyChallenge(c)
+	if err != nil {
+		return err
+	}
+
+	record(c, uOut.ID, "auth.login", uOut.ID, nil, map[string]bool{"2fa": true})
+
+	return authToken(c, uOut, true)
+}
+
+func Login2FA(c echo.Context) error {
+	uOut, err := verifyChallenge(c)
+	if err != nil {
+		return err
+	}
+
+	record(c, uOut.ID, "session.login", uOut.ID, nil, map[string]bool{"2fa": true})
+
+	return loginSession(c, uOut, true)
+}
+
+func forced2FA(id int64) bool {
+	return config.AdminForce2FA() && id == 1
+}
+
+// TwoFactorRequired keeps an admin who has not passed 2FA away from
+// everything but the enrollment endpoints when 2FA is forced by config.
+func TwoFactorRequired(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cl := claims(c)
+		if !cl.TwoFactor && forced2FA(cl.ID) && !strings.HasPrefix(c.Path(), "/v1/users/2fa") {
+			return UnauthorizedErr("2FA Required")
+		}
+
+		return next(c)
+	}
+}
+
+func Enroll2FA(c echo.Context) error {
+	id := claims(c).ID
+	key, err := users.EnrollTOTP(audited(c, id, "2fa.enroll", nil, nil), id)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		if err == users.ErrTOTPEnabled {
+			return BadRequestErr("2FA Already Enabled")
+		}
+		return err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	return Render(c, http.StatusCreated, users.EnrollOutput{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	})
+}
+
+func QRCode2FA(c echo.Context) error {
+	key, err := users.TOTPKey(c.Request().Context(), claims(c).ID)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		if err == users.ErrTOTPNotEnrolled {
+			return NotFoundErr("2FA Not Enrolled")
+		}
+		return err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	return c.Blob(http.StatusOK, "image/png", buf.Bytes())
+}
+
+func Activate2FA(c echo.Context) error {
+	cIn := new(users.CodeInput)
+	if err := c.Bind(cIn); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Data Invalid")
+	}
+	if err := c.Validate(cIn); err != nil {
+		return err
+	}
+
+	id := claims(c).ID
+	ctx := audited(c, id, "2fa.activate", map[string]bool{"two_factor": false}, map[string]bool{"two_factor": true})
+	codes, err := users.ActivateTOTP(ctx, id, cIn.Code)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		switch err {
+		case users.ErrTOTPEnabled:
+			return BadRequestErr("2FA Already Enabled")
+		case users.ErrTOTPNotEnrolled:
+			return BadRequestErr("2FA Not Enrolled")
+		case users.ErrCodeInvalid:
+			return BadRequestErr("Code Incorrect")
+		}
+		return err
+	}
+
+	return Render(c, http.StatusOK, users.RecoveryOutput{RecoveryCodes: codes})
+}
+
+func Disable2FA(c echo.Context) error {
+	cIn := new(users.CodeInput)
+	if err := c.Bind(cIn); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Data Invalid")
+	}
+	if err := c.Validate(cIn); err != nil {
+		return err
+	}
+
+	id := claims(c).ID
+	if forced2FA(id) {
+		return BadRequestErr("2FA Forced For Admin")
+	}
+
+	ctx := audited(c, id, "2fa.disable", map[string]bool{"two_factor": true}, map[string]bool{"two_factor": false})
+	if err := users.DisableTOTP(ctx, id, cIn.Code); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		switch err {
+		case users.ErrTOTPNotEnrolled:
+			return BadRequestErr("2FA Not Enrolled")
+		case users.ErrCodeInvalid:
+			return BadRequestErr("Code Incorrect")
+		}
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"echo-demo/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signed(t *testing.T, method jwt.SigningMethod, claims jwt.Claims, key any) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseChallenge(t *testing.T) {
+	claims, valid, err := newChallenge(7, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.RegisteredClaims.ID) != 32 {
+		t.Errorf("jti = %q, want 16 random bytes in hex", claims.RegisteredClaims.ID)
+	}
+	other, otherStr, err := newChallenge(7, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.RegisteredClaims.ID == claims.RegisteredClaims.ID {
+		t.Error("two challenges share a jti")
+	}
+	_, expired, err := newChallenge(7, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later := jwt.NewNumericDate(time.Now().Add(time.Minute))
+	key := challengeKey(config.SignKey())
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", valid, true},
+		{"expired", expired, false},
+		{"other signature", valid[:strings.LastIndex(valid, ".")] + otherStr[strings.LastIndex(otherStr, "."):], false},
+		{"access token", signed(t, jwt.SigningMethodHS256, &JwtCustomClaims{ID: 7, Name: "alice", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: later}}, config.SignKey()), false},
+		{"access key", signed(t, jwt.SigningMethodHS256, &challengeClaims{7, challengePurpose, jwt.RegisteredClaims{ID: "a", ExpiresAt: later}}, config.SignKey()), false},
+		{"other purpose", signed(t, jwt.SigningMethodHS256, &challengeClaims{7, "reset", jwt.RegisteredClaims{ID: "a", ExpiresAt: later}}, key), false},
+		{"no jti", signed(t, jwt.SigningMethodHS256, &challengeClaims{7, challengePurpose, jwt.RegisteredClaims{ExpiresAt: later}}, key), false},
+		{"other method", signed(t, jwt.SigningMethodHS512, &challengeClaims{7, challengePurpose, jwt.RegisteredClaims{ID: "a", ExpiresAt: later}}, key), false},
+		{"unsigned", signed(t, jwt.SigningMethodNone, &challengeClaims{7, challengePurpose, jwt.RegisteredClaims{ID: "a", ExpiresAt: later}}, jwt.UnsafeAllowNoneSignatureType), false},
+	}
+	for _, tt := range tests {
+		got, err := parseChallenge(tt.token)
+		if tt.ok {
+			if err != nil || got.ID != 7 || got.RegisteredClaims.ID != claims.RegisteredClaims.ID {
+				t.Errorf("%s: got %+v, %v", tt.name, got, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
+
+// A challenge is no access token, whatever it claims.
+func TestChallengeNotAccessToken(t *testing.T) {
+	_, tokenStr, err := newChallenge(1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = jwt.ParseWithClaims(tokenStr, new(JwtCustomClaims), func(t *jwt.Token) (any, error) {
+		return config.VerifyKey(), nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("err = %v, want a signature error", err)
+	}
+}
@@ -1,18 +1,24 @@
 package handlers
 
 import (
+	"bytes"
 	"crypto/md5"
+	"echo-demo/audit"
 	"echo-demo/config"
 	"echo-demo/db"
 	"echo-demo/users"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
@@ -20,26 +26,37 @@ import (
 func Auth(c echo.Context) error {
 	aIn := new(users.AuthInput)
 	if err := c.Bind(aIn); err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return BadRequestErr("Data Invalid")
 	}
 	if err := c.Validate(aIn); err != nil {
-		c.Echo().Logger.Debug(err)
-		return BadRequestErr("Validation Faild")
+		return err
 	}
 
-	uOut, err := users.Auth(aIn.Name, aIn.Password)
+	uOut, err := users.Auth(c.Request().Context(), aIn.Name, aIn.Password)
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		if err == db.ErrNotFound {
+			record(c, 0, "auth.failed", 0, nil, map[string]string{"name": aIn.Name})
 			return UnauthorizedErr("Name|Password Incorrect")
 		}
 		return err
 	}
 
+	if uOut.TwoFactor {
+		record(c, uOut.ID, "auth.challenge", uOut.ID, nil, nil)
+		return challenge(c, uOut.ID)
+	}
+
+	record(c, uOut.ID, "auth.login", uOut.ID, nil, nil)
+	return authToken(c, uOut, false)
+}
+
+func authToken(c echo.Context, uOut *users.Output, twoFactor bool) error {
 	claims := &JwtCustomClaims{
 		uOut.ID,
 		uOut.Name,
+		twoFactor,
 		jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 72)),
 		},
@@ -52,7 +69,7 @@ func Auth(c echo.Context) error {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, users.AuthOutput{uOut, tokenStr})
+	return Render(c, http.StatusOK, users.AuthOutput{User: uOut, Token: tokenStr})
 }
 
 func CreateUser(c echo.Context) error {
@@ -62,122 +79,353 @@ func CreateUser(c echo.Context) error {
 
 	uIn := new(users.Input)
 	if err := c.Bind(uIn); err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return BadRequestErr("Data Invalid")
 	}
 	if err := c.Validate(uIn); err != nil {
-		c.Echo().Logger.Debug(err)
-		return BadRequestErr("Validation Faild")
+		return err
+	}
+	if err := checkPassword(c, uIn.Name, uIn.Password); err != nil {
+		return err
 	}
 
-	uOut, err := users.NewOne(uIn.Name, uIn.Password, uIn.Age, time.Now())
+	ctx := audited(c, claims(c).ID, "user.create", nil, audit.Result)
+	uOut, err := users.NewOne(ctx, uIn.Name, uIn.Password, uIn.Age, uIn.Email, time.Now())
 	if err != nil {
-		c.Echo().Logger.Debug(err)
-		if err == db.ErrDupRows {
-			return BadRequestErr("User(%s) Duplicate", uIn.Name)
-		}
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return err
 	}
 
-	return c.JSON(http.StatusCreated, uOut)
+	if err := sendVerification(c.Request().Context(), uOut); err != nil {
+		log.ErrorContext(c.Request().Context(), "verification mail failed", "err", err)
+	}
+
+	return withETag(c, http.StatusCreated, uOut)
 }
 
 func GetOneUser(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return BadRequestErr("Id(%s) Invalid", c.Param("id"))
 	}
 
-	uOut, err := users.GetOneByID(int64(id))
+	uOut, err := users.GetOneByID(c.Request().Context(), int64(id))
 	if err != nil {
-		c.Echo().Logger.Debug(err)
-		if err == db.ErrNotFound {
-			return NotFoundErr("User(id:%d) Not Found", id)
-		}
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return err
 	}
 
-	return c.JSON(http.StatusOK, uOut)
+	return withETag(c, http.StatusOK, uOut)
 }
 
 func GetAllUsers(c echo.Context) error {
+	return listUsers(c, claims(c).ID)
+}
+
+// listUsers answers a page of users in an envelope, with an RFC 8288 Link
+// header to the next page. Only the admin may see deleted users.
+func listUsers(c echo.Context, authID int64) error {
 	limit, err := strconv.Atoi(c.QueryParam("limit"))
 	if err != nil || limit <= 0 {
 		limit = config.RecordLimit()
 	}
-	offset, err := strconv.Atoi(c.QueryParam("offset"))
-	if err != nil || offset < 0 {
-		offset = config.RecordOffset()
+	if limit > config.RecordMax() {
+		limit = config.RecordMax()
 	}
+	withTotal, _ := strconv.ParseBool(c.QueryParam("total"))
 
-	uOuts, err := users.GetAll(int64(limit), int64(offset))
+	q, err := users.ParseQuery(c.QueryParams())
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("%s", err)
+	}
+	if q.IncludeDeleted, _ = strconv.ParseBool(c.QueryParam("include_deleted")); q.IncludeDeleted && authID != 1 {
+		return UnauthorizedErr("Admin Required")
+	}
+
+	lOut, err := users.GetAll(c.Request().Context(), q, int64(limit), c.QueryParam("cursor"), withTotal)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return err
 	}
 
-	return c.JSON(http.StatusOK, uOuts)
+	if len(lOut.NextCursor) > 0 {
+		params := c.QueryParams()
+		params.Set("cursor", lOut.NextCursor)
+		params.Set("limit", strconv.Itoa(limit))
+		next := url.URL{
+			Scheme:   c.Scheme(),
+			Host:     c.Request().Host,
+			Path:     c.Request().URL.Path,
+			RawQuery: params.Encode(),
+		}
+		c.Response().Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+	}
+
+	return Render(c, http.StatusOK, lOut)
 }
 
 func UpdateUser(c echo.Context) error {
+	return updateUser(c, claims(c).ID)
+}
+
+// updateUser replaces the user of the id parameter, which the admin and
+// the user itself may do.
+func updateUser(c echo.Context, actorID int64) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return BadRequestErr("Id(%s) Invalid", c.Param("id"))
 	}
 
-	if authID := claims(c).ID; authID != 1 && authID != int64(id) {
+	if actorID != 1 && actorID != int64(id) {
 		return UnauthorizedErr("Admin or User(id:%d) Required", id)
 	}
 
-	uIn := new(users.Input)
+	version, err := ifMatch(c, int64(id))
+	if err != nil {
+		return err
+	}
+
+	uIn := new(users.UpdateInput)
 	if err := c.Bind(uIn); err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return BadRequestErr("Data Invalid")
 	}
 	if err := c.Validate(uIn); err != nil {
-		c.Echo().Logger.Debug(err)
-		return BadRequestErr("Validation Faild")
+		return err
+	}
+	ch := &users.Changes{Name: &uIn.Name, Age: &uIn.Age, Email: &uIn.Email}
+	if len(uIn.Password) > 0 {
+		if err := checkPassword(c, uIn.Name, uIn.Password); err != nil {
+			return err
+		}
+		ch.Password = &uIn.Password
 	}
 
-	uOut, err := users.UpdateOne(int64(id), uIn.Name, uIn.Password, uIn.Age)
+	before, _ := users.GetOneByID(c.Request().Context(), int64(id))
+
+	var secrets []string
+	if ch.Password != nil {
+		secrets = append(secrets, "password")
+	}
+	ctx := audited(c, actorID, "user.update", before, audit.Result, secrets...)
+	uOut, err := users.UpdateOne(ctx, int64(id), ch, version)
 	if err != nil {
-		c.Echo().Logger.Debug(err)
-		if err == db.ErrNotFound {
-			return NotFoundErr("User(id:%d) Not Found", id)
-		} else if err == db.ErrDupRows {
-			return BadRequestErr("User(%s) Duplicate", uIn.Name)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return err
+	}
+
+	// Only a new address needs verifying. Without a before to compare
+	// with, the mail goes out anyway.
+	if before == nil || uOut.Email != before.Email {
+		if err := sendVerification(c.Request().Context(), uOut); err != nil {
+			log.ErrorContext(c.Request().Context(), "verification mail failed", "err", err)
 		}
+	}
+
+	return withETag(c, http.StatusOK, uOut)
+}
+
+// PatchUser accepts a JSON Merge Patch (RFC 7396), or a JSON Patch
+// (RFC 6902) when sent as application/json-patch+json, and updates only
+// the fields the patch changes.
+func PatchUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Id(%s) Invalid", c.Param("id"))
+	}
+
+	if authID := claims(c).ID; authID != 1 && authID != int64(id) {
+		return UnauthorizedErr("Admin or User(id:%d) Required", id)
+	}
+
+	version, err := ifMatch(c, int64(id))
+	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, uOut)
+	patch, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+
+	uOut, err := users.GetOneByID(c.Request().Context(), int64(id))
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return err
+	}
+	// The patch is applied to what was read here, so the write must not
+	// go through if someone else got in between.
+	if version > 0 && version != uOut.Version {
+		return PreconditionFailedErr("User(id:%d) Modified", id)
+	}
+	version = uOut.Version
+
+	orig := users.PatchDoc{Name: uOut.Name, Age: uOut.Age, Email: uOut.Email}
+	doc, err := json.Marshal(orig)
+	if err != nil {
+		return err
+	}
+
+	ctype, _, _ := strings.Cut(c.Request().Header.Get(echo.HeaderContentType), ";")
+	switch strings.TrimSpace(ctype) {
+	case "application/json-patch+json":
+		ops, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			log.DebugContext(c.Request().Context(), "request failed", "err", err)
+			return BadRequestErr("Patch Invalid")
+		}
+		doc, err = ops.Apply(doc)
+		if err != nil {
+			log.DebugContext(c.Request().Context(), "request failed", "err", err)
+			return BadRequestErr("Patch Failed")
+		}
+	case "application/merge-patch+json", echo.MIMEApplicationJSON:
+		doc, err = jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			log.DebugContext(c.Request().Context(), "request failed", "err", err)
+			return BadRequestErr("Patch Invalid")
+		}
+	default:
+		return echo.ErrUnsupportedMediaType
+	}
+
+	pIn := new(users.PatchDoc)
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(pIn); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Data Invalid")
+	}
+	if err := c.Validate(pIn); err != nil {
+		return err
+	}
+
+	ch := new(users.Changes)
+	if pIn.Name != orig.Name {
+		ch.Name = &pIn.Name
+	}
+	if pIn.Age != orig.Age {
+		ch.Age = &pIn.Age
+	}
+	if pIn.Email != orig.Email {
+		ch.Email = &pIn.Email
+	}
+
+	ctx := audited(c, claims(c).ID, "user.patch", uOut, audit.Result)
+	uOut, err = users.UpdateOne(ctx, int64(id), ch, version)
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return err
+	}
+
+	if ch.Email != nil {
+		if err := sendVerification(c.Request().Context(), uOut); err != nil {
+			log.ErrorContext(c.Request().Context(), "verification mail failed", "err", err)
+		}
+	}
+
+	return withETag(c, http.StatusOK, uOut)
+}
+
+// ChangePassword only lets users change their own password, and only with
+// the current one.
+func ChangePassword(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Id(%s) Invalid", c.Param("id"))
+	}
+
+	if authID := claims(c).ID; authID != int64(id) {
+		return UnauthorizedErr("User(id:%d) Required", id)
+	}
+
+	pIn := new(users.PasswordInput)
+	if err := c.Bind(pIn); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Data Invalid")
+	}
+	if err := c.Validate(pIn); err != nil {
+		return err
+	}
+	if err := checkPassword(c, claims(c).Name, pIn.NewPassword); err != nil {
+		return err
+	}
+
+	ctx := audited(c, int64(id), "user.password_change", nil, nil, "password")
+	if _, err := users.ChangePassword(ctx, int64(id), pIn.CurrentPassword, pIn.NewPassword); err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		if err == users.ErrPasswordIncorrect {
+			record(c, int64(id), "user.password_change_failed", int64(id), nil, nil)
+			return UnauthorizedErr("Password Incorrect")
+		}
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
 }
 
+// DeleteUser soft deletes, unless purge=true asks for a hard delete.
 func DeleteUser(c echo.Context) error {
-	if authID := claims(c).ID; authID != 1 {
+	authID := claims(c).ID
+	if authID != 1 {
 		return UnauthorizedErr("Admin Required")
 	}
 
+	return deleteUser(c, authID)
+}
+
+func deleteUser(c echo.Context, actorID int64) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return BadRequestErr("Id(%s) Invalid", c.Param("id"))
 	}
 
-	err = users.DeleteOne(int64(id))
+	version, err := ifMatch(c, int64(id))
 	if err != nil {
-		c.Echo().Logger.Debug(err)
-		if err == db.ErrNotFound {
-			return NotFoundErr("User(id:%d) Not Found", id)
-		}
+		return err
+	}
+
+	before, _ := users.GetOneByID(c.Request().Context(), int64(id))
+	if purge, _ := strconv.ParseBool(c.QueryParam("purge")); purge {
+		err = users.PurgeOne(audited(c, actorID, "user.purge", before, nil), int64(id), version)
+	} else {
+		err = users.DeleteOne(audited(c, actorID, "user.delete", before, nil), int64(id), version)
+	}
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
 		return err
 	}
 
 	return c.NoContent(http.StatusNoContent)
 }
 
+func RestoreUser(c echo.Context) error {
+	authID := claims(c).ID
+	if authID != 1 {
+		return UnauthorizedErr("Admin Required")
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return BadRequestErr("Id(%s) Invalid", c.Param("id"))
+	}
+
+	uOut, err := users.RestoreOne(audited(c, authID, "user.restore", nil, audit.Result), int64(id))
+	if err != nil {
+		log.DebugContext(c.Request().Context(), "request failed", "err", err)
+		return err
+	}
+
+	return withETag(c, http.StatusOK, uOut)
+}
+
 func Upload(c echo.Context) error {
 	name := c.FormValue("name")
 	email := c.FormValue("email")
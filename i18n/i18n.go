@@ -0,0 +1,96 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Catalogs map the English text, format verbs and all, to its translation.
+// English itself needs no catalog.
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+var (
+	tags     = []language.Tag{language.English}
+	catalogs = map[language.Tag]map[string]string{}
+	matcher  = language.NewMatcher(tags)
+)
+
+func CatalogInit() error {
+	entries, err := locales.ReadDir("locales")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		tag, err := language.Parse(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return fmt.Errorf("I18n: %s: %w", entry.Name(), err)
+		}
+
+		data, err := locales.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			return err
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("I18n: %s: %w", entry.Name(), err)
+		}
+
+		catalogs[tag] = catalog
+		tags = append(tags, tag)
+	}
+	matcher = language.NewMatcher(tags)
+
+	return nil
+}
+
+// Match picks the best supported language for an Accept-Language header,
+// English when nothing fits.
+func Match(acceptLanguage string) language.Tag {
+	prefs, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, index, conf := matcher.Match(prefs...)
+	if conf == language.No {
+		return language.English
+	}
+
+	return tags[index]
+}
+
+// Sprintf formats the translation of format, or format itself when the
+// catalog of tag does not have it.
+func Sprintf(tag language.Tag, format string, a ...any) string {
+	if text, ok := catalogs[tag][format]; ok {
+		format = text
+	}
+	if len(a) == 0 {
+		return format
+	}
+
+	return fmt.Sprintf(format, a...)
+}
+
+// Message is text that is translated only once the reader is known.
+type Message struct {
+	Format string
+	Args   []any
+}
+
+func NewMessage(format string, a ...any) *Message {
+	return &Message{Format: format, Args: a}
+}
+
+// String is the English text.
+func (m *Message) String() string {
+	return fmt.Sprintf(m.Format, m.Args...)
+}
+
+func (m *Message) Localize(tag language.Tag) string {
+	return Sprintf(tag, m.Format, m.Args...)
+}
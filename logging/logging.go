@@ -0,0 +1,247 @@
+package logging
+
+import (
+	"context"
+	"echo-demo/config"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Keys whose values never make it into the log, matched in lower case
+// anywhere in the key.
+var secretKeys = []string{"password", "token", "secret", "authorization", "cookie", "recovery"}
+
+const redacted = "[REDACTED]"
+
+var ErrLevelInvalid = errors.New("Logging: Level Invalid")
+
+var (
+	base   atomic.Pointer[slog.Handler]
+	mutex  sync.RWMutex
+	level  = slog.LevelInfo
+	levels = map[string]slog.Level{}
+)
+
+func init() {
+	h := newHandler(os.Stderr, "text")
+	base.Store(&h)
+}
+
+// Init builds the handler and levels from the config and makes the main
+// logger the default. Until then, everything goes to stderr as text at
+// the info level.
+func Init() error {
+	switch config.LogFormat() {
+	case "json", "text":
+	default:
+		return fmt.Errorf("Logging: Format(%s) Invalid", config.LogFormat())
+	}
+
+	def, err := parseLevel(config.LogLevel())
+	if err != nil {
+		return err
+	}
+	pkgs := map[string]slog.Level{}
+	for _, entry := range config.LogLevels() {
+		pkg, val, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return fmt.Errorf("Logging: Entry(%s) Invalid", entry)
+		}
+		if pkgs[pkg], err = parseLevel(val); err != nil {
+			return err
+		}
+	}
+
+	mutex.Lock()
+	level, levels = def, pkgs
+	mutex.Unlock()
+
+	h := newHandler(os.Stderr, config.LogFormat())
+	base.Store(&h)
+	slog.SetDefault(For("main"))
+
+	return nil
+}
+
+// SetLevel changes the level of pkg, or the default one when pkg is empty,
+// until the next Init.
+func SetLevel(pkg string, s string) error {
+	l, err := parseLevel(s)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(pkg) == 0 {
+		level = l
+		return nil
+	}
+	levels[pkg] = l
+
+	return nil
+}
+
+// Levels returns the default level and those of the packages that have
+// their own.
+func Levels() (def string, pkgs map[string]string) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	pkgs = make(map[string]string, len(levels))
+	for k, v := range levels {
+		pkgs[k] = v.String()
+	}
+	return level.String(), pkgs
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrLevelInvalid, s)
+	}
+	return l, nil
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	// Levels are up to the package loggers.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug - 4, ReplaceAttr: redact}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+func secret(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if secret(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// RedactURL hides the values of secret query parameters, like the tokens
+// of links in mails.
+func RedactURL(u *url.URL) string {
+	if len(u.RawQuery) == 0 {
+		return u.RequestURI()
+	}
+
+	query := u.Query()
+	for key := range query {
+		if secret(key) {
+			query[key] = []string{redacted}
+		}
+	}
+	v := *u
+	v.RawQuery = strings.ReplaceAll(query.Encode(), url.QueryEscape(redacted), redacted)
+	return v.RequestURI()
+}
+
+// For returns the logger of a package, which logs at the level the config
+// gives the package, or the default one. Loggers may be made before Init.
+func For(pkg string) *slog.Logger {
+	return slog.New(&handler{pkg: pkg})
+}
+
+func enabled(pkg string, l slog.Level) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if min, ok := levels[pkg]; ok {
+		return l >= min
+	}
+	return l >= level
+}
+
+// handler adds the package, the request and the trace of the context to
+// each record and hands it to the current base handler. With calls are
+// replayed on it, since it may change after the logger was made.
+type handler struct {
+	pkg  string
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return enabled(h.pkg, l)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(slog.String("pkg", h.pkg))
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		r.AddAttrs(slog.String("request_id", req.id))
+		if user := req.user.Load(); user > 0 {
+			r.AddAttrs(slog.Int64("user_id", user))
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+
+	inner := *base.Load()
+	for _, w := range h.with {
+		inner = w(inner)
+	}
+	return inner.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.and(func(inner slog.Handler) slog.Handler { return inner.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.and(func(inner slog.Handler) slog.Handler { return inner.WithGroup(name) })
+}
+
+func (h *handler) and(w func(slog.Handler) slog.Handler) slog.Handler {
+	with := append(h.with[:len(h.with):len(h.with)], w)
+	return &handler{pkg: h.pkg, with: with}
+}
+
+type requestKey struct{}
+
+type request struct {
+	id   string
+	user atomic.Int64
+}
+
+// WithRequest returns ctx naming the request id on the lines logged with
+// it or any context made from it.
+func WithRequest(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey{}, &request{id: id})
+}
+
+// SetUser names the authenticated user as well, from the moment it is
+// known. ctx must come from WithRequest.
+func SetUser(ctx context.Context, id int64) {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.user.Store(id)
+	}
+}
+
+// RequestID returns the request id ctx carries, empty outside requests.
+func RequestID(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return req.id
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"echo-demo/config"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+var sender Mailer
+
+func SenderInit() error {
+	switch config.Mailer() {
+	case "smtp":
+		host, _, err := net.SplitHostPort(config.SMTPAddr())
+		if err != nil {
+			return err
+		}
+		var auth smtp.Auth
+		if len(config.SMTPUser()) > 0 {
+			auth = smtp.PlainAuth("", config.SMTPUser(), config.SMTPPassword(), host)
+		}
+		sender = &SMTP{Addr: config.SMTPAddr(), Auth: auth, From: config.MailFrom()}
+	case "file":
+		sender = &File{Path: config.MailFile(), From: config.MailFrom()}
+	case "log", "":
+		sender = &Log{Logger: log.Default(), From: config.MailFrom()}
+	default:
+		return fmt.Errorf("Mailer: Unknown kind %q", config.Mailer())
+	}
+
+	return nil
+}
+
+func Sender() Mailer {
+	return sender
+}
+
+func Send(msg *Message) error {
+	return sender.Send(msg)
+}
+
+// SMTP delivers mail through a real server.
+type SMTP struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+func (m *SMTP) Send(msg *Message) error {
+	var sb strings.Builder
+	if err := write(&sb, m.From, msg); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, []byte(sb.String()))
+}
+
+// File appends every message to a local mbox-like file, so that mail can
+// be read without a mail server.
+type File struct {
+	Path  string
+	From  string
+	mutex sync.Mutex
+}
+
+func (m *File) Send(msg *Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "From %s %s\r\n", m.From, time.Now().Format(time.ANSIC)); err != nil {
+		return err
+	}
+	if err := write(f, m.From, msg); err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, "\r\n")
+	return err
+}
+
+// Log only prints messages, which is enough for local development.
+type Log struct {
+	Logger *log.Logger
+	From   string
+}
+
+func (m *Log) Send(msg *Message) error {
+	m.Logger.Printf("Mail from %s to %s: %s\n%s", m.From, msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+func write(w io.Writer, from string, msg *Message) error {
+	_, err := fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		from, msg.To, msg.Subject, time.Now().Format(time.RFC1123Z), msg.Body)
+	return err
+}
@@ -2,23 +2,30 @@ package main
 
 import (
 	"context"
+	"echo-demo/audit"
+	"echo-demo/certs"
 	"echo-demo/config"
 	"echo-demo/db"
 	"echo-demo/handlers"
+	"echo-demo/i18n"
+	"echo-demo/logging"
+	"echo-demo/mailer"
+	"echo-demo/password"
+	"echo-demo/retry"
 	"echo-demo/stats"
+	"echo-demo/tracing"
+	"echo-demo/users"
 	"echo-demo/vk"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/go-playground/validator"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/gorilla/sessions"
-	"github.com/labstack/echo-contrib/session"
-	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -35,89 +42,233 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 	return nil
 }
 
+// newValidator reports failed fields by the names clients send them as.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	return v
+}
+
+// fatal logs err and exits, like log.Fatal.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// connect tries init with backoff, logging each attempt, and gives up on
+// the whole server when the dependency never shows up.
+func connect(ctx context.Context, name string, init func() error) {
+	err := retry.Do(ctx, init, func(attempt int, wait time.Duration, err error) {
+		if wait > 0 {
+			slog.Warn("connect failed", "dep", name, "attempt", attempt, "retry_in", wait.Round(time.Millisecond), "err", err)
+		}
+	})
+	if err != nil {
+		fatal(name+": connect failed", err)
+	}
+	slog.Info("connected", "dep", name)
+}
+
+// watch logs the state changes of a dependency once the server runs.
+func watch(ctx context.Context, name string, ping func(context.Context) error) {
+	retry.Watch(ctx, ping, func(err error) {
+		if err != nil {
+			slog.Warn("connection lost", "dep", name, "err", err)
+		} else {
+			slog.Info("reconnected", "dep", name)
+		}
+	})
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gencert" {
+		if err := gencert(os.Args[2:]); err != nil {
+			fatal("Gencert", err)
+		}
+		return
+	}
+
 	e := echo.New()
-	e.Validator = &CustomValidator{validator: validator.New()}
-	e.Debug = true
+	e.Validator = &CustomValidator{validator: newValidator()}
+	e.Binder = new(handlers.Binder)
+	e.HTTPErrorHandler = handlers.ErrorHandler
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
 
 	if len(os.Args) > 1 {
 		if err := config.Etcd(os.Args[1]); err != nil {
-			e.Logger.Fatal("Config from Etcd: ", err)
+			fatal("Config from Etcd", err)
 		}
 	} else {
 		if err := config.Init(); err != nil {
-			e.Logger.Fatal("Config from File: ", err)
+			fatal("Config from File", err)
 		}
 	}
 
-	if err := db.ConnInit(); err != nil {
-		e.Logger.Fatal("Database: ", err)
+	if err := logging.Init(); err != nil {
+		fatal("Logging", err)
+	}
+
+	shutdownTracing, err := tracing.Init(ctx)
+	if err != nil {
+		fatal("Tracing", err)
+	}
+
+	e.Debug = config.Debug()
+
+	connect(ctx, "Database", db.ConnInit)
+	if total, healthy := db.Replicas(); total > 0 {
+		slog.Info("replicas", "healthy", healthy, "total", total)
+	}
+
+	connect(ctx, "Valkey", vk.ClientInit)
+
+	if err := mailer.SenderInit(); err != nil {
+		fatal("Mailer", err)
+	}
+
+	if err := i18n.CatalogInit(); err != nil {
+		fatal("I18n", err)
 	}
 
-	if err := vk.ClientInit(); err != nil {
-		e.Logger.Fatal("Valkey: ", err)
+	if err := password.ListInit(); err != nil {
+		fatal("Breached List", err)
 	}
 
-	e.Use(middleware.Logger())
+	e.Use(handlers.RequestID)
+	e.Use(handlers.Trace)
+	e.Use(handlers.ReadYourWrites)
+	e.Use(handlers.AccessLog)
 	e.Use(middleware.Recover())
+	if err := useMiddleware(e); err != nil {
+		fatal("Middleware", err)
+	}
+
+	var tlsServer *certs.Server
+	if len(config.TLSCert()) > 0 {
+		if tlsServer, err = certs.Load(); err != nil {
+			fatal("TLS", err)
+		}
+		go tlsServer.Watch(ctx)
+
+		if len(config.TLSClientCA()) > 0 {
+			byName, err := clientCertUsers()
+			if err != nil {
+				fatal("TLS", err)
+			}
+			e.Use(handlers.ClientCert(byName))
+		}
+	}
 
 	e.Use(middleware.Static("./static"))
 
 	s := stats.New()
 	go func() {
 		admin := echo.New()
-		admin.Debug = true
-		admin.GET("/stats", s.Handler)
-		admin.Logger.Fatal(admin.Start(config.AdminAddr()))
+		admin.Debug = config.Debug()
+		admin.Validator = e.Validator
+		admin.HTTPErrorHandler = handlers.ErrorHandler
+		admin.Use(handlers.RequestID)
+		admin.Use(handlers.AccessLog)
+		admin.Use(middleware.Recover())
+		adminRoutes(admin, s)
+		fatal("Admin server", admin.Start(config.AdminAddr()))
 	}()
 
 	e.Use(s.Process)
 
-	gv := e.Group("/v1")
-	gv.POST("/auth", handlers.Auth)
-	gv.POST("/upload", handlers.Upload)
-
-	gu := gv.Group("/users")
-	gu.Use(echojwt.WithConfig(echojwt.Config{
-		NewClaimsFunc: func(c echo.Context) jwt.Claims {
-			return new(handlers.JwtCustomClaims)
-		},
-		SigningKey: config.VerifyKey(),
-	}))
-	gu.GET("", handlers.GetAllUsers)
-	gu.GET("/:id", handlers.GetOneUser)
-	gu.POST("", handlers.CreateUser)
-	gu.PUT("/:id", handlers.UpdateUser)
-	gu.DELETE("/:id", handlers.DeleteUser)
-
-	gr := gv.Group("/roles")
-	gr.Use(session.Middleware(sessions.NewCookieStore(config.SessionKey())))
-	gr.POST("/login", handlers.Login)
-	gr.GET("", handlers.GetAllRoles)
-	gr.GET("/:id", handlers.GetOneRole)
-	gr.POST("", handlers.CreateRole)
-	gr.PUT("/:id", handlers.UpdateRole)
-	gr.DELETE("/:id", handlers.DeleteRole)
+	spec := routes(e)
+	// Global middleware runs after routing, so the route is known.
+	if config.Debug() {
+		e.Use(spec.ValidateResponses)
+	}
+	if config.ValidateRequests() {
+		e.Use(spec.Validate)
+	}
 
-	ctx, stop := signal.NotifyContext(context.Background(),
-		syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	defer stop()
+	go watch(ctx, "Database", db.Ping)
+	go watch(ctx, "Valkey", vk.Ping)
+	go db.WatchReplicas(ctx, func(num int, err error) {
+		if err != nil {
+			slog.Warn("replica down", "replica", num, "err", err)
+		} else {
+			slog.Info("replica up", "replica", num)
+		}
+	})
+
+	go func() {
+		ticker := time.NewTicker(config.PurgeInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Purged by no one in particular, as far as the audit log goes.
+				pctx := audit.Pending(ctx, &audit.Event{Action: "user.purge"}, nil, nil)
+				num, err := users.Purge(pctx, time.Now().Add(-config.PurgeRetention()))
+				if err != nil {
+					slog.Error("purge failed", "err", err)
+				} else if num > 0 {
+					slog.Info("purged", "users", num)
+				}
+			}
+		}
+	}()
 
 	go func() {
-		err := e.Start(config.ServerAddr())
+		var err error
+		if tlsServer != nil {
+			e.TLSServer.Addr = config.ServerAddr()
+			e.TLSServer.TLSConfig = tlsServer.Config()
+			err = e.StartServer(e.TLSServer)
+		} else {
+			err = e.Start(config.ServerAddr())
+		}
 		if err != nil && err != http.ErrServerClosed {
-			e.Logger.Fatal("Server close: ", err)
+			fatal("Server close", err)
 		}
 	}()
 
+	var redirect *http.Server
+	if tlsServer != nil && len(config.RedirectAddr()) > 0 {
+		redirect = &http.Server{
+			Addr:              config.RedirectAddr(),
+			Handler:           redirectHTTPS(config.ServerAddr()),
+			ReadHeaderTimeout: e.TLSServer.ReadHeaderTimeout,
+			IdleTimeout:       e.TLSServer.IdleTimeout,
+		}
+		go func() {
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Redirect server", err)
+			}
+		}()
+	}
+
 	//wait for signals to gracefully shutdown the server.
 	<-ctx.Done()
 
-	fmt.Print("Shutting down the server...")
+	slog.Info("shutting down the server")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	if redirect != nil {
+		redirect.Shutdown(ctx)
+	}
 	if err := e.Shutdown(ctx); err != nil {
-		e.Logger.Fatal(err)
+		fatal("Shutdown", err)
+	}
+	// Spans still buffered are sent before leaving.
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("tracing shutdown failed", "err", err)
 	}
-	fmt.Println("done.")
+	slog.Info("server stopped")
 }
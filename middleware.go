@@ -0,0 +1,86 @@
+package main
+
+import (
+	"echo-demo/config"
+	"echo-demo/handlers"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// useMiddleware adds the middleware of the config section of the same
+// name to the public server, and bounds its connections. Body limits and
+// timeouts go by the route, global middleware runs after routing.
+func useMiddleware(e *echo.Echo) error {
+	mc := config.Middleware()
+
+	for _, srv := range []*http.Server{e.Server, e.TLSServer} {
+		srv.ReadHeaderTimeout = time.Duration(mc.ReadHeaderTimeout) * time.Second
+		srv.IdleTimeout = time.Duration(mc.IdleTimeout) * time.Second
+	}
+
+	if len(mc.CORSOrigins) > 0 {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:     mc.CORSOrigins,
+			AllowMethods:     mc.CORSMethods,
+			AllowHeaders:     mc.CORSHeaders,
+			AllowCredentials: mc.CORSCredentials,
+			ExposeHeaders:    []string{"ETag", "Link", "Retry-After", echo.HeaderXRequestID, echo.HeaderContentDisposition},
+			MaxAge:           mc.CORSMaxAge,
+		}))
+	}
+
+	secure := middleware.SecureConfig{
+		XFrameOptions:         mc.FrameOptions,
+		HSTSMaxAge:            mc.HSTSMaxAge,
+		HSTSExcludeSubdomains: !mc.HSTSSubdomains,
+		ContentSecurityPolicy: mc.ContentSecurityPolicy,
+		ReferrerPolicy:        mc.ReferrerPolicy,
+	}
+	if mc.ContentTypeNosniff {
+		secure.ContentTypeNosniff = "nosniff"
+	}
+	e.Use(middleware.SecureWithConfig(secure))
+
+	if len(mc.Compression) > 0 {
+		for _, enc := range mc.Compression {
+			if enc != "br" && enc != "gzip" {
+				return fmt.Errorf("Compression(%s) Invalid", enc)
+			}
+		}
+		if mc.CompressionLevel < 1 || mc.CompressionLevel > 9 {
+			return fmt.Errorf("CompressionLevel(%d) Invalid", mc.CompressionLevel)
+		}
+		e.Use(handlers.Compress(mc.Compression, mc.CompressionLevel, mc.CompressionMinLen))
+	}
+
+	limits := map[string]string{}
+	for _, pair := range mc.BodyLimits {
+		route, limit, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("BodyLimits(%s) Invalid", pair)
+		}
+		limits[strings.TrimSpace(route)] = strings.TrimSpace(limit)
+	}
+	bodyLimit, err := handlers.BodyLimit(mc.BodyLimit, limits)
+	if err != nil {
+		return err
+	}
+	e.Use(bodyLimit)
+
+	if mc.RequestTimeout > 0 {
+		e.Use(middleware.ContextTimeoutWithConfig(middleware.ContextTimeoutConfig{
+			Skipper: func(c echo.Context) bool {
+				return slices.Contains(mc.TimeoutExempt, c.Path())
+			},
+			Timeout: time.Duration(mc.RequestTimeout) * time.Second,
+		}))
+	}
+
+	return nil
+}
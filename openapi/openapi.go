@@ -0,0 +1,317 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+const Version = "3.1.0"
+
+// The document types cover the part of OpenAPI this project uses.
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem maps lower case methods to operations.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+// Op describes an operation with Go values, which are turned into
+// schemas when it is added.
+type Op struct {
+	Summary string
+	Tags    []string
+	// Security names a scheme of the spec, empty for public operations.
+	Security string
+	Query    []Param
+	// Body is a value of the request body type, sent as JSON, form, XML,
+	// MessagePack or CBOR unless BodyTypes says otherwise, or a Raw.
+	// Bodies adds media types whose body looks different.
+	Body      any
+	BodyTypes []string
+	Bodies    map[string]any
+	// Responses maps the success statuses to a value of the body type,
+	// nil for none or a Raw for bodies that are not JSON. Errors are
+	// always problem details. ResponseTypes lists the media types of
+	// the bodies, those of the spec by default.
+	Responses     map[int]any
+	ResponseTypes []string
+}
+
+// Param is a query parameter. Example gives its type.
+type Param struct {
+	Name        string
+	Example     any
+	Description string
+	Required    bool
+}
+
+// Raw is a body of one of the given media types that has no schema, like
+// an image or CSV.
+type Raw []string
+
+const ProblemType = "application/problem+json"
+
+var bodyTypes = []string{echo.MIMEApplicationJSON, echo.MIMEApplicationForm, echo.MIMEApplicationXML, "application/msgpack", "application/cbor"}
+
+// Spec collects the operations of the routes added to it. ResponseTypes
+// are the media types of response bodies where operations do not say,
+// JSON unless set before adding them. Aliases map other names of request
+// media types, like text/xml, to those of the spec.
+type Spec struct {
+	ResponseTypes []string
+	Aliases       map[string]string
+
+	mutex   sync.Mutex
+	doc     *Document
+	gen     *generator
+	problem *Schema
+	ids     map[string]bool
+	data    []byte
+}
+
+func New(title string, version string, problem any) *Spec {
+	s := &Spec{
+		doc: &Document{
+			OpenAPI: Version,
+			Info:    Info{Title: title, Version: version},
+			Paths:   map[string]PathItem{},
+			Components: Components{
+				Schemas: map[string]*Schema{},
+				SecuritySchemes: map[string]*SecurityScheme{
+					"bearer":  {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+					"session": {Type: "apiKey", In: "cookie", Name: "session"},
+				},
+			},
+		},
+	}
+	s.ResponseTypes = []string{echo.MIMEApplicationJSON}
+	s.ids = map[string]bool{}
+	s.gen = &generator{schemas: s.doc.Components.Schemas, names: map[reflect.Type]string{}}
+	s.problem = s.gen.schema(reflect.TypeOf(problem))
+
+	return s
+}
+
+// Add documents route with op and returns route, so that registration and
+// documentation stay side by side.
+func (s *Spec) Add(route *echo.Route, op Op) *echo.Route {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path, params := Path(route.Path)
+	o := &Operation{
+		OperationID: s.operationID(route),
+		Summary:     op.Summary,
+		Tags:        op.Tags,
+		Responses:   map[string]*Response{},
+	}
+	for _, name := range params {
+		schema := &Schema{Type: "string"}
+		if name == "id" || strings.HasSuffix(name, "_id") {
+			schema = &Schema{Type: "integer", Format: "int64", Minimum: ptr(1.0)}
+		}
+		o.Parameters = append(o.Parameters, &Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+	for _, p := range op.Query {
+		o.Parameters = append(o.Parameters, &Parameter{
+			Name:        p.Name,
+			In:          "query",
+			Description: p.Description,
+			Required:    p.Required,
+			Schema:      s.gen.schema(reflect.TypeOf(p.Example)),
+		})
+	}
+
+	if op.Body != nil || len(op.Bodies) > 0 {
+		o.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{}}
+		if op.Body != nil {
+			types := op.BodyTypes
+			if len(types) == 0 {
+				types = bodyTypes
+			}
+			s.content(o.RequestBody.Content, op.Body, types)
+		}
+		for t, body := range op.Bodies {
+			s.content(o.RequestBody.Content, body, []string{t})
+		}
+	}
+
+	responseTypes := op.ResponseTypes
+	if len(responseTypes) == 0 {
+		responseTypes = s.ResponseTypes
+	}
+	for status, body := range op.Responses {
+		r := &Response{Description: http.StatusText(status)}
+		if body != nil {
+			r.Content = map[string]*MediaType{}
+			s.content(r.Content, body, responseTypes)
+		}
+		o.Responses[strconv.Itoa(status)] = r
+	}
+	o.Responses["default"] = &Response{
+		Description: "Problem",
+		Content:     map[string]*MediaType{ProblemType: {Schema: s.problem}},
+	}
+
+	if len(op.Security) > 0 {
+		o.Security = []map[string][]string{{op.Security: {}}}
+	}
+
+	item, ok := s.doc.Paths[path]
+	if !ok {
+		item = PathItem{}
+		s.doc.Paths[path] = item
+	}
+	item[strings.ToLower(route.Method)] = o
+	s.data = nil
+
+	return route
+}
+
+// content adds body under each of types, or under its own for a Raw.
+func (s *Spec) content(content map[string]*MediaType, body any, types []string) {
+	if raw, ok := body.(Raw); ok {
+		for _, t := range raw {
+			content[t] = &MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+		}
+		return
+	}
+
+	schema := s.gen.schema(reflect.TypeOf(body))
+	for _, t := range types {
+		content[t] = &MediaType{Schema: schema}
+	}
+}
+
+// Operation returns the operation of a route, nil when it is not
+// documented.
+func (s *Spec) Operation(method string, path string) *Operation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, _ := Path(path)
+	return s.doc.Paths[p][strings.ToLower(method)]
+}
+
+// Schema resolves a reference of the spec.
+func (s *Spec) Schema(ref string) *Schema {
+	return s.doc.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+}
+
+func (s *Spec) MarshalJSON() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data == nil {
+		data, err := json.Marshal(s.doc)
+		if err != nil {
+			return nil, err
+		}
+		s.data = data
+	}
+
+	return s.data, nil
+}
+
+// Handler serves the document.
+func (s *Spec) Handler(c echo.Context) error {
+	data, err := s.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return c.JSONBlob(http.StatusOK, data)
+}
+
+// Path turns an Echo path like /users/:id into /users/{id} and returns
+// the names of its parameters.
+func Path(route string) (path string, params []string) {
+	parts := strings.Split(route, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			params = append(params, part[1:])
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+
+	return strings.Join(parts, "/"), params
+}
+
+// operationID is the handler name without its package, like GetOneUser,
+// followed by the method when the handler serves more than one.
+func (s *Spec) operationID(route *echo.Route) string {
+	name := route.Name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	if s.ids[name] {
+		name += strings.ToUpper(route.Method[:1]) + strings.ToLower(route.Method[1:])
+	}
+	s.ids[name] = true
+
+	return name
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
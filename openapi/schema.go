@@ -0,0 +1,160 @@
+package openapi
+
+import (
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// generator turns Go types into schemas. Named structs become components
+// referenced by name, json tags name the properties and validate tags add
+// the constraints.
+type generator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func (g *generator) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && len(t.Name()) > 0:
+		return &Schema{Ref: "#/components/schemas/" + g.component(t)}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.object(t)
+	}
+
+	// Interfaces take anything.
+	return &Schema{}
+}
+
+// component registers a named struct once, under its package qualified
+// name when the plain one is taken.
+func (g *generator) component(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if _, taken := g.schemas[name]; taken {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	g.names[t] = name
+	// Reserved first, so that recursive types end in a reference.
+	g.schemas[name] = &Schema{}
+	*g.schemas[name] = *g.object(t)
+
+	return name
+}
+
+func (g *generator) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && len(name) == 0 {
+			// Embedded structs like jwt.RegisteredClaims are not part
+			// of any body here.
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+
+		prop := g.schema(f.Type)
+		if constrain(prop, f.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+
+	return s
+}
+
+// constrain applies a validate tag to s and tells whether it makes the
+// field required.
+func constrain(s *Schema, tag string) (required bool) {
+	if len(tag) == 0 {
+		return false
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		num, _ := strconv.ParseFloat(param, 64)
+		switch name {
+		case "required":
+			required = true
+		case "email":
+			s.Format = "email"
+		case "gte", "min":
+			if s.Type == "string" {
+				s.MinLength = ptr(int(num))
+			} else {
+				s.Minimum = ptr(num)
+			}
+		case "lte", "max":
+			if s.Type == "string" {
+				s.MaxLength = ptr(int(num))
+			} else {
+				s.Maximum = ptr(num)
+			}
+		case "oneof":
+			s.Enum = strings.Fields(param)
+		}
+	}
+
+	return required
+}
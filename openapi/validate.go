@@ -0,0 +1,431 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"echo-demo/logging"
+
+	"github.com/labstack/echo/v4"
+)
+
+var log = logging.For("openapi")
+
+// maxRecorded caps how much of a response is kept to be checked.
+const maxRecorded = 1 << 20
+
+// Issue is one way a request or response breaks the spec. In is path,
+// query or body, Name the parameter or the dotted path of a body value.
+// Param completes Rule, like the type or the minimum.
+type Issue struct {
+	In    string
+	Name  string
+	Rule  string
+	Param string
+}
+
+func (i Issue) String() string {
+	name := i.Name
+	if len(name) == 0 {
+		name = "(root)"
+	}
+	if len(i.Param) == 0 {
+		return fmt.Sprintf("%s %s: %s", i.In, name, i.Rule)
+	}
+	return fmt.Sprintf("%s %s: %s %s", i.In, name, i.Rule, i.Param)
+}
+
+// RequestError lists every issue of a request, not just the first one.
+type RequestError struct {
+	Issues []Issue
+}
+
+func (e *RequestError) Error() string {
+	issues := make([]string, 0, len(e.Issues))
+	for _, i := range e.Issues {
+		issues = append(issues, i.String())
+	}
+	return "Request: Spec Violated (" + strings.Join(issues, ", ") + ")"
+}
+
+// Validate checks the parameters and JSON body of requests to documented
+// routes before their handlers run. Bodies of other media types are left
+// to the handlers, bodies of undocumented media types are refused, but
+// for Raw bodies, whose handlers know best what they take.
+func (s *Spec) Validate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		op := s.Operation(c.Request().Method, c.Path())
+		if op == nil {
+			return next(c)
+		}
+
+		v := &validator{spec: s}
+		for _, p := range op.Parameters {
+			switch p.In {
+			case "path":
+				v.param(p, c.Param(p.Name))
+			case "query":
+				val, ok := c.QueryParams()[p.Name]
+				if !ok {
+					if p.Required {
+						v.add("query", p.Name, "required", "")
+					}
+					continue
+				}
+				v.param(p, val[0])
+			}
+		}
+
+		if op.RequestBody != nil && hasBody(c.Request()) {
+			mt, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+			if alias, ok := s.Aliases[mt]; ok {
+				mt = alias
+			}
+			content, ok := op.RequestBody.Content[mt]
+			if !ok && !raw(op.RequestBody) {
+				return echo.ErrUnsupportedMediaType
+			}
+			if ok && isJSON(mt) && content.Schema != nil && content.Schema.Format != "binary" {
+				data, err := io.ReadAll(c.Request().Body)
+				if err != nil {
+					return err
+				}
+				c.Request().Body = io.NopCloser(bytes.NewReader(data))
+
+				body, err := decode(data)
+				if err != nil {
+					log.DebugContext(c.Request().Context(), "body invalid", "err", err)
+					return echo.NewHTTPError(http.StatusBadRequest, "Data Invalid").SetInternal(err)
+				}
+				// PATCH bodies other than JSON Patch are merge patches,
+				// which leave out what stays and null what goes.
+				v.partial = c.Request().Method == http.MethodPatch && mt != "application/json-patch+json"
+				v.value("body", nil, content.Schema, body)
+			}
+		}
+
+		if len(v.issues) > 0 {
+			return &RequestError{Issues: v.issues}
+		}
+
+		return next(c)
+	}
+}
+
+// raw tells whether every media type of body is a Raw one.
+func raw(body *RequestBody) bool {
+	for _, content := range body.Content {
+		if content.Schema == nil || content.Schema.Format != "binary" {
+			return false
+		}
+	}
+	return len(body.Content) > 0
+}
+
+// ValidateResponses checks the JSON responses of documented routes and
+// logs where they drift from the spec. Responses go out either way, so it
+// is meant for development.
+func (s *Spec) ValidateResponses(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		op := s.Operation(c.Request().Method, c.Path())
+		if op == nil || c.Request().Method == http.MethodHead {
+			return next(c)
+		}
+
+		res := c.Response()
+		rec := &recorder{ResponseWriter: res.Writer}
+		res.Writer = rec
+		err := next(c)
+		res.Writer = rec.ResponseWriter
+		if err != nil || rec.truncated {
+			// Errors are rendered after this returns.
+			return err
+		}
+
+		issues := s.checkResponse(op, res.Status, res.Header().Get(echo.HeaderContentType), rec.buf.Bytes())
+		if len(issues) > 0 {
+			log.WarnContext(c.Request().Context(), "response drifts from the spec",
+				"method", c.Request().Method, "path", c.Path(), "status", res.Status, "issues", issues)
+		}
+
+		return nil
+	}
+}
+
+func (s *Spec) checkResponse(op *Operation, status int, ctype string, data []byte) []Issue {
+	r, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		r = op.Responses["default"]
+		if status < http.StatusBadRequest || r == nil {
+			return []Issue{{In: "response", Rule: "status", Param: strconv.Itoa(status)}}
+		}
+	}
+	if len(r.Content) == 0 {
+		if len(data) > 0 {
+			return []Issue{{In: "response", Rule: "content"}}
+		}
+		return nil
+	}
+
+	mt, _, _ := mime.ParseMediaType(ctype)
+	content, ok := r.Content[mt]
+	if !ok {
+		return []Issue{{In: "response", Rule: "media_type", Param: mt}}
+	}
+	if !isJSON(mt) || content.Schema == nil || content.Schema.Format == "binary" {
+		return nil
+	}
+
+	body, err := decode(data)
+	if err != nil {
+		return []Issue{{In: "response", Rule: "json"}}
+	}
+	v := &validator{spec: s}
+	v.value("response", nil, content.Schema, body)
+
+	return v.issues
+}
+
+// recorder keeps a copy of what is written, up to maxRecorded.
+type recorder struct {
+	http.ResponseWriter
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.buf.Len()+len(b) > maxRecorded {
+		r.truncated = true
+	} else if !r.truncated {
+		r.buf.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) Flush() {
+	// Streams are not checked, they rarely are a single document.
+	r.truncated = true
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+type validator struct {
+	spec    *Spec
+	partial bool
+	issues  []Issue
+}
+
+func (v *validator) add(in string, name string, rule string, param string) {
+	v.issues = append(v.issues, Issue{In: in, Name: name, Rule: rule, Param: param})
+}
+
+// param checks a path or query parameter, which comes as text.
+func (v *validator) param(p *Parameter, val string) {
+	s := v.resolve(p.Schema)
+	if s == nil {
+		return
+	}
+
+	switch s.Type {
+	case "integer":
+		num, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			v.add(p.In, p.Name, "type", s.Type)
+			return
+		}
+		v.bounds(p.In, p.Name, s, float64(num))
+	case "number":
+		num, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			v.add(p.In, p.Name, "type", s.Type)
+			return
+		}
+		v.bounds(p.In, p.Name, s, num)
+	case "boolean":
+		if _, err := strconv.ParseBool(val); err != nil {
+			v.add(p.In, p.Name, "type", s.Type)
+		}
+	case "string":
+		if s.Format == "date-time" {
+			// Parameters take a plain date as well.
+			if _, err := time.Parse(time.DateOnly, val); err == nil {
+				return
+			}
+		}
+		v.str(p.In, p.Name, s, val)
+	}
+}
+
+// value checks a decoded JSON value against s. path leads to the value
+// from the root of the body.
+func (v *validator) value(in string, path []string, s *Schema, val any) {
+	s = v.resolve(s)
+	if s == nil || len(s.Type) == 0 {
+		return
+	}
+	name := strings.Join(path, ".")
+	if val == nil {
+		if !v.partial {
+			v.add(in, name, "type", s.Type)
+		}
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := val.(map[string]any)
+		if !ok {
+			v.add(in, name, "type", s.Type)
+			return
+		}
+		if !v.partial {
+			for _, req := range s.Required {
+				if _, ok := obj[req]; !ok {
+					v.add(in, strings.Join(append(path[:len(path):len(path)], req), "."), "required", "")
+				}
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			prop, ok := s.Properties[key]
+			if !ok {
+				// Unknown properties are the business of the handler.
+				prop = s.AdditionalProperties
+			}
+			if prop != nil {
+				v.value(in, append(path[:len(path):len(path)], key), prop, obj[key])
+			}
+		}
+	case "array":
+		arr, ok := val.([]any)
+		if !ok {
+			v.add(in, name, "type", s.Type)
+			return
+		}
+		for i, item := range arr {
+			v.value(in, append(path[:len(path):len(path)], strconv.Itoa(i)), s.Items, item)
+		}
+	case "integer":
+		num, ok := val.(json.Number)
+		if !ok {
+			v.add(in, name, "type", s.Type)
+			return
+		}
+		i, err := num.Int64()
+		if err != nil {
+			v.add(in, name, "type", s.Type)
+			return
+		}
+		v.bounds(in, name, s, float64(i))
+	case "number":
+		num, ok := val.(json.Number)
+		if !ok {
+			v.add(in, name, "type", s.Type)
+			return
+		}
+		f, _ := num.Float64()
+		v.bounds(in, name, s, f)
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			v.add(in, name, "type", s.Type)
+		}
+	case "string":
+		str, ok := val.(string)
+		if !ok {
+			v.add(in, name, "type", s.Type)
+			return
+		}
+		v.str(in, name, s, str)
+	}
+}
+
+func (v *validator) str(in string, name string, s *Schema, val string) {
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, val) {
+		v.add(in, name, "enum", strings.Join(s.Enum, " "))
+		return
+	}
+
+	length := utf8.RuneCountInString(val)
+	if s.MinLength != nil && length < *s.MinLength {
+		v.add(in, name, "minLength", strconv.Itoa(*s.MinLength))
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		v.add(in, name, "maxLength", strconv.Itoa(*s.MaxLength))
+	}
+
+	var err error
+	switch s.Format {
+	case "email":
+		// Empty means no email, required says whether it may be left out.
+		if len(val) > 0 {
+			var addr *mail.Address
+			if addr, err = mail.ParseAddress(val); err == nil && addr.Address != val {
+				err = errors.New("not a bare address")
+			}
+		}
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, val)
+	case "byte":
+		_, err = base64.StdEncoding.DecodeString(val)
+	}
+	if err != nil {
+		v.add(in, name, "format", s.Format)
+	}
+}
+
+func (v *validator) bounds(in string, name string, s *Schema, num float64) {
+	if s.Minimum != nil && num < *s.Minimum {
+		v.add(in, name, "minimum", strconv.FormatFloat(*s.Minimum, 'f', -1, 64))
+	}
+	if s.Maximum != nil && num > *s.Maximum {
+		v.add(in, name, "maximum", strconv.FormatFloat(*s.Maximum, 'f', -1, 64))
+	}
+}
+
+// resolve follows a reference, nil when it leads nowhere.
+func (v *validator) resolve(s *Schema) *Schema {
+	if s != nil && len(s.Ref) > 0 {
+		return v.spec.Schema(s.Ref)
+	}
+	return s
+}
+
+func decode(data []byte) (any, error) {
+	var body any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("openapi: data after the JSON value")
+	}
+	return body, nil
+}
+
+func hasBody(r *http.Request) bool {
+	return r.ContentLength > 0 || (r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody)
+}
+
+func isJSON(mt string) bool {
+	return mt == echo.MIMEApplicationJSON || strings.HasSuffix(mt, "+json")
+}
@@ -0,0 +1,96 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"echo-demo/config"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const prefixLen = 5
+
+// Breached hashes are bucketed by the first five hex digits of their SHA-1,
+// the same k-anonymity ranges the Pwned Passwords API uses. A lookup only
+// touches one small sorted bucket of suffixes.
+var breached map[string][]string
+
+// ListInit loads the breached password list, if one is configured. Lines
+// are either SHA-1 hashes in the Pwned Passwords "HASH:COUNT" format or
+// plain passwords.
+func ListInit() error {
+	path := config.BreachedList()
+	if len(path) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	list, err := readList(f)
+	if err != nil {
+		return err
+	}
+	breached = list
+
+	return nil
+}
+
+// readList buckets the hashes of a list by prefix, each bucket sorted.
+func readList(r io.Reader) (map[string][]string, error) {
+	list := map[string][]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		if !isSHA1(hash) {
+			hash = sha1Hex(line)
+		}
+		hash = strings.ToUpper(hash)
+		list[hash[:prefixLen]] = append(list[hash[:prefixLen]], hash[prefixLen:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, suffixes := range list {
+		sort.Strings(suffixes)
+	}
+
+	return list, nil
+}
+
+func Breached(password string) bool {
+	if breached == nil {
+		return false
+	}
+
+	hash := strings.ToUpper(sha1Hex(password))
+	suffixes := breached[hash[:prefixLen]]
+	i := sort.SearchStrings(suffixes, hash[prefixLen:])
+
+	return i < len(suffixes) && suffixes[i] == hash[prefixLen:]
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func isSHA1(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
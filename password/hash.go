@@ -0,0 +1,80 @@
+package password
+
+import (
+	"context"
+	"echo-demo/config"
+	"echo-demo/tracing"
+	"strings"
+
+	"github.com/alexedwards/argon2id"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var tracer = otel.Tracer("echo-demo/password")
+
+func params() *argon2id.Params {
+	return &argon2id.Params{
+		Memory:      uint32(config.Argon2Memory()),
+		Iterations:  uint32(config.Argon2Iterations()),
+		Parallelism: uint8(config.Argon2Parallelism()),
+		SaltLength:  uint32(config.Argon2SaltLength()),
+		KeyLength:   uint32(config.Argon2KeyLength()),
+	}
+}
+
+// Hash uses the Argon2id parameters from the config.
+// Thanks Alex Edwards
+func Hash(ctx context.Context, password string) (hash string, err error) {
+	p := params()
+	_, span := tracer.Start(ctx, "argon2.hash")
+	span.SetAttributes(attribute.Int("argon2.memory", int(p.Memory)), attribute.Int("argon2.iterations", int(p.Iterations)))
+	defer tracing.End(span, &err)
+
+	return argon2id.CreateHash(password, p)
+}
+
+// Verify checks a password against an Argon2id or a legacy bcrypt hash.
+// rehash tells whether a matching hash should be replaced with Hash,
+// because it is bcrypt or weaker than the configured parameters.
+func Verify(ctx context.Context, password string, hash string) (match bool, rehash bool, err error) {
+	_, span := tracer.Start(ctx, "argon2.verify")
+	defer tracing.End(span, &err)
+
+	if isBcrypt(hash) {
+		span.SetName("bcrypt.verify")
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	}
+
+	match, p, err := argon2id.CheckHash(password, hash)
+	if err != nil || !match {
+		return false, false, err
+	}
+
+	return true, weaker(p, params()), nil
+}
+
+func weaker(p *argon2id.Params, want *argon2id.Params) bool {
+	return p.Memory < want.Memory ||
+		p.Iterations < want.Iterations ||
+		p.Parallelism < want.Parallelism ||
+		p.SaltLength < want.SaltLength ||
+		p.KeyLength < want.KeyLength
+}
+
+func isBcrypt(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
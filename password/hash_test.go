@@ -0,0 +1,75 @@
+package password
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWeaker(t *testing.T) {
+	want := &argon2id.Params{Memory: 65536, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32}
+	tests := []struct {
+		name string
+		p    argon2id.Params
+		want bool
+	}{
+		{"same", *want, false},
+		{"stronger", argon2id.Params{Memory: 131072, Iterations: 4, Parallelism: 8, SaltLength: 32, KeyLength: 64}, false},
+		{"memory", argon2id.Params{Memory: 32768, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32}, true},
+		{"iterations", argon2id.Params{Memory: 65536, Iterations: 2, Parallelism: 4, SaltLength: 16, KeyLength: 32}, true},
+		{"parallelism", argon2id.Params{Memory: 65536, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32}, true},
+		{"salt", argon2id.Params{Memory: 65536, Iterations: 3, Parallelism: 4, SaltLength: 8, KeyLength: 32}, true},
+		{"key", argon2id.Params{Memory: 65536, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 16}, true},
+		{"one up, one down", argon2id.Params{Memory: 131072, Iterations: 1, Parallelism: 4, SaltLength: 16, KeyLength: 32}, true},
+	}
+	for _, tt := range tests {
+		if got := weaker(&tt.p, want); got != tt.want {
+			t.Errorf("%s: weaker = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A match tells whether to rehash: always for bcrypt, for Argon2id when
+// the config asks for more than the hash has.
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	current, err := Hash(ctx, "secret1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := argon2id.CreateHash("secret1", &argon2id.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		match    bool
+		rehash   bool
+		err      bool
+	}{
+		{"current", "secret1", current, true, false, false},
+		{"current, wrong", "secret2", current, false, false, false},
+		{"old params", "secret1", old, true, true, false},
+		{"old params, wrong", "secret2", old, false, false, false},
+		{"bcrypt", "secret1", string(legacy), true, true, false},
+		{"bcrypt, wrong", "secret2", string(legacy), false, false, false},
+		{"bcrypt 2y", "secret1", "$2y$" + string(legacy[4:]), true, true, false},
+		{"bcrypt, broken", "secret1", "$2a$04$short", false, false, true},
+		{"unknown", "secret1", "plain", false, false, true},
+	}
+	for _, tt := range tests {
+		match, rehash, err := Verify(ctx, tt.password, tt.hash)
+		if match != tt.match || rehash != tt.rehash || (err != nil) != tt.err {
+			t.Errorf("%s: match %v, rehash %v, err %v, want %v, %v, error %v", tt.name, match, rehash, err, tt.match, tt.rehash, tt.err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package password
+
+import (
+	"echo-demo/config"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+
+	// Format and Args make up Message, for translations.
+	Format string `json:"-"`
+	Args   []any  `json:"-"`
+}
+
+// PolicyError lists every rule a password breaks, not just the first one.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	rules := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		rules = append(rules, v.Rule)
+	}
+	return "Password: Policy Violated (" + strings.Join(rules, ", ") + ")"
+}
+
+// Check validates a password for the named user against the configured
+// policy and the breached password list.
+func Check(name string, password string) error {
+	var vs []Violation
+	add := func(rule string, format string, a ...any) {
+		vs = append(vs, Violation{Rule: rule, Message: fmt.Sprintf(format, a...), Format: format, Args: a})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if min := config.PasswordMinLength(); length < min {
+		add("min_length", "Password must be at least %d characters long", min)
+	}
+	if max := config.PasswordMaxLength(); max > 0 && length > max {
+		add("max_length", "Password must be at most %d characters long", max)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if config.PasswordRequireUpper() && !upper {
+		add("upper", "Password must contain an upper case letter")
+	}
+	if config.PasswordRequireLower() && !lower {
+		add("lower", "Password must contain a lower case letter")
+	}
+	if config.PasswordRequireDigit() && !digit {
+		add("digit", "Password must contain a digit")
+	}
+	if config.PasswordRequireSymbol() && !symbol {
+		add("symbol", "Password must contain a symbol")
+	}
+
+	if config.PasswordDisallowName() && len(name) > 0 &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(name)) {
+		add("name", "Password must not contain the user name")
+	}
+
+	if Breached(password) {
+		add("breached", "Password has appeared in a data breach")
+	}
+
+	if len(vs) > 0 {
+		return &PolicyError{Violations: vs}
+	}
+
+	return nil
+}
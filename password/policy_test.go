@@ -0,0 +1,110 @@
+package password
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func rules(err error) []string {
+	var pe *PolicyError
+	if !errors.As(err, &pe) {
+		return nil
+	}
+	var rs []string
+	for _, v := range pe.Violations {
+		rs = append(rs, v.Rule)
+	}
+	return rs
+}
+
+// The rules of the default config: 8 to 128 characters, a lower case
+// letter, a digit and not the user name.
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		rules    []string
+	}{
+		{"good", "alice", "correct horse 9", nil},
+		{"no upper or symbol needed", "alice", "abcdefg1", nil},
+		{"short", "alice", "abc1", []string{"min_length"}},
+		{"just long enough", "alice", "abcdefg1", nil},
+		{"long", "alice", strings.Repeat("a1", 65), []string{"max_length"}},
+		{"just short enough", "alice", strings.Repeat("a1", 64), nil},
+		{"runes, not bytes", "alice", "äöüßäöü1", nil},
+		{"few runes, many bytes", "alice", "äöü1", []string{"min_length"}},
+		{"no lower", "alice", "ABCDEFG1", []string{"lower"}},
+		{"no digit", "alice", "abcdefgh", []string{"digit"}},
+		{"digits only", "alice", "12345678", []string{"lower"}},
+		{"symbols only", "alice", "!@#$%^&*", []string{"lower", "digit"}},
+		{"name", "alice", "xxALICE1yy", []string{"name"}},
+		{"no name", "", "abcdefg1", nil},
+		{"empty", "alice", "", []string{"min_length", "lower", "digit"}},
+	}
+	for _, tt := range tests {
+		if got := rules(Check(tt.user, tt.password)); !reflect.DeepEqual(got, tt.rules) {
+			t.Errorf("%s: rules = %q, want %q", tt.name, got, tt.rules)
+		}
+	}
+}
+
+func TestCheckMessage(t *testing.T) {
+	var pe *PolicyError
+	if !errors.As(Check("alice", "abc"), &pe) {
+		t.Fatal("no policy error")
+	}
+	v := pe.Violations[0]
+	if v.Message != "Password must be at least 8 characters long" || v.Format != "Password must be at least %d characters long" || !reflect.DeepEqual(v.Args, []any{8}) {
+		t.Errorf("violation = %+v", v)
+	}
+	if got, want := pe.Error(), "Password: Policy Violated (min_length, digit)"; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+}
+
+func TestBreached(t *testing.T) {
+	list, err := readList(strings.NewReader(strings.Join([]string{
+		"# Pwned Passwords, and a plain one",
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:3730471",
+		"5BAA600000000000000000000000000000000000:1",
+		"",
+		"password1",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"5BAA6": {"00000000000000000000000000000000000", "1E4C9B93F3F0682250B6CF8331B7EE68FD8"},
+		"E38AD": {"214943DAAD1D64C102FAEC29DE4AFE9DA3D"},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("buckets = %q, want %q", list, want)
+	}
+
+	saved := breached
+	breached = list
+	t.Cleanup(func() { breached = saved })
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"password1", true},
+		{"Password1", false},
+		{"123456", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := Breached(tt.password); got != tt.want {
+			t.Errorf("Breached(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+	if got := rules(Check("alice", "password1")); !reflect.DeepEqual(got, []string{"breached"}) {
+		t.Errorf("rules = %q, want breached", got)
+	}
+}
@@ -0,0 +1,77 @@
+package retry
+
+import (
+	"context"
+	"echo-demo/config"
+	"math/rand/v2"
+	"time"
+)
+
+// Report is told about every failed attempt and how long it waits before
+// the next one. wait is 0 after the last attempt.
+type Report func(attempt int, wait time.Duration, err error)
+
+// Do calls fn until it succeeds, the attempts of the config are used up or
+// ctx is done. The waits between attempts grow exponentially up to the
+// configured maximum, each randomized to between half and all of it, so
+// that restarted instances do not all knock at once.
+func Do(ctx context.Context, fn func() error, report Report) (err error) {
+	attempts := max(config.RetryAttempts(), 1)
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= attempts {
+			report(attempt, 0, err)
+			return err
+		}
+
+		wait := Backoff(attempt)
+		report(attempt, wait, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Backoff is the wait after the given attempt, counting from 1.
+func Backoff(attempt int) time.Duration {
+	wait := config.RetryDelay()
+	for i := 1; i < attempt && wait < config.RetryMaxDelay(); i++ {
+		wait *= 2
+	}
+	wait = min(wait, config.RetryMaxDelay())
+
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// Watch calls ping at the configured interval until ctx is done, and tells
+// report whenever the outcome flips: a non-nil error when the dependency
+// goes away, nil when it is back. The clients reconnect on their own,
+// Watch only makes it visible.
+func Watch(ctx context.Context, ping func(ctx context.Context) error, report func(err error)) {
+	ticker := time.NewTicker(config.MonitorInterval())
+	defer ticker.Stop()
+
+	up := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pctx, cancel := context.WithTimeout(ctx, config.MonitorInterval())
+		err := ping(pctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if (err == nil) != up {
+			up = err == nil
+			report(err)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"echo-demo/audit"
+	"echo-demo/config"
+	"echo-demo/handlers"
+	"echo-demo/openapi"
+	"echo-demo/stats"
+	"echo-demo/users"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	echojwt "github.com/labstack/echo-jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+// Query parameters of the user lists.
+var listParams = []openapi.Param{
+	{Name: "limit", Example: 0, Description: "Page size, capped by the server"},
+	{Name: "cursor", Example: "", Description: "next_cursor of the previous page"},
+	{Name: "total", Example: false, Description: "Count all matches as well"},
+	{Name: "include_deleted", Example: false, Description: "Admin only"},
+	{Name: "name", Example: "", Description: "Name prefix"},
+	{Name: "name_contains", Example: ""},
+	{Name: "age_min", Example: int64(0)},
+	{Name: "age_max", Example: int64(0)},
+	{Name: "registered_after", Example: time.Time{}, Description: "RFC 3339 or date"},
+	{Name: "registered_before", Example: time.Time{}, Description: "RFC 3339 or date"},
+	{Name: "q", Example: "", Description: "Full text search on name and email"},
+	{Name: "sort", Example: "", Description: "Fields among id, name, age and reg_date, - for descending"},
+}
+
+func newJWTAuth() echo.MiddlewareFunc {
+	return echojwt.WithConfig(echojwt.Config{
+		NewClaimsFunc: func(c echo.Context) jwt.Claims {
+			return new(handlers.JwtCustomClaims)
+		},
+		// Services signed in by client certificate need no token.
+		Skipper:        handlers.CertAuthenticated,
+		SigningKey:     config.VerifyKey(),
+		SuccessHandler: handlers.Authenticated,
+	})
+}
+
+// adminRoutes registers the stats and, for the admin only, the runtime
+// diagnostics on the admin server.
+func adminRoutes(admin *echo.Echo, s *stats.Stats) {
+	admin.GET("/stats", s.Handler)
+
+	gd := admin.Group("/debug", newJWTAuth(), handlers.AdminRequired, handlers.TwoFactorRequired)
+	gd.GET("/runtime", handlers.Runtime)
+	gd.GET("/build", handlers.Build)
+	gd.GET("/log-level", handlers.GetLogLevels)
+	gd.PUT("/log-level", handlers.SetLogLevel)
+	gd.POST("/capture", handlers.Capture)
+
+	gd.GET("/pprof/*", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+	gd.GET("/pprof/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+	gd.GET("/pprof/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+	gd.GET("/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	gd.POST("/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	gd.GET("/pprof/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+}
+
+// routes registers the API on e, each route with its documentation, and
+// returns the resulting spec.
+func routes(e *echo.Echo) *openapi.Spec {
+	spec := openapi.New("echo-demo", "1.0.0", handlers.Problem{})
+	spec.ResponseTypes = handlers.RenderTypes
+	spec.Aliases = handlers.MediaAliases
+
+	spec.Add(e.GET("/openapi.json", spec.Handler), openapi.Op{
+		Summary:       "This document",
+		Tags:          []string{"docs"},
+		Responses:     map[int]any{http.StatusOK: map[string]any{}},
+		ResponseTypes: []string{echo.MIMEApplicationJSON},
+	})
+
+	jwtAuth := newJWTAuth()
+
+	gv := e.Group("/v1")
+	spec.Add(gv.POST("/auth", handlers.Auth), openapi.Op{
+		Summary: "Get a JWT with name and password",
+		Tags:    []string{"auth"},
+		Body:    users.AuthInput{},
+		Responses: map[int]any{
+			http.StatusOK:       users.AuthOutput{},
+			http.StatusAccepted: users.ChallengeOutput{},
+		},
+	})
+	spec.Add(gv.POST("/auth/2fa", handlers.Auth2FA), openapi.Op{
+		Summary:   "Answer a 2FA challenge for a JWT",
+		Tags:      []string{"auth"},
+		Body:      users.ChallengeInput{},
+		Responses: map[int]any{http.StatusOK: users.AuthOutput{}},
+	})
+	spec.Add(gv.POST("/upload", handlers.Upload), openapi.Op{
+		Summary:   "Upload files to the static directory",
+		Tags:      []string{"files"},
+		Body:      openapi.Raw{echo.MIMEMultipartForm},
+		Responses: map[int]any{http.StatusOK: openapi.Raw{echo.MIMETextHTML}},
+	})
+	spec.Add(gv.POST("/password/forgot", handlers.ForgotPassword), openapi.Op{
+		Summary:   "Mail a password reset token",
+		Tags:      []string{"password"},
+		Body:      users.ForgotInput{},
+		Responses: map[int]any{http.StatusAccepted: nil},
+	})
+	spec.Add(gv.POST("/password/reset", handlers.ResetPassword), openapi.Op{
+		Summary:   "Set a new password with a reset token",
+		Tags:      []string{"password"},
+		Body:      users.ResetInput{},
+		Responses: map[int]any{http.StatusNoContent: nil},
+	})
+	spec.Add(gv.GET("/email/verify", handlers.VerifyEmail), openapi.Op{
+		Summary:   "Verify an email address from the mailed link",
+		Tags:      []string{"email"},
+		Query:     []openapi.Param{{Name: "token", Example: "", Required: true}},
+		Responses: map[int]any{http.StatusOK: users.Output{}},
+	})
+	spec.Add(gv.POST("/email/verify", handlers.VerifyEmail), openapi.Op{
+		Summary:   "Verify an email address with the mailed token",
+		Tags:      []string{"email"},
+		Body:      users.VerifyInput{},
+		Responses: map[int]any{http.StatusOK: users.Output{}},
+	})
+
+	gu := gv.Group("/users")
+	gu.Use(jwtAuth)
+	gu.Use(handlers.TwoFactorRequired)
+	spec.Add(gu.POST("/2fa/enroll", handlers.Enroll2FA), openapi.Op{
+		Summary:   "Start a TOTP enrollment",
+		Tags:      []string{"2fa"},
+		Security:  "bearer",
+		Responses: map[int]any{http.StatusCreated: users.EnrollOutput{}},
+	})
+	spec.Add(gu.GET("/2fa/qrcode", handlers.QRCode2FA), openapi.Op{
+		Summary:   "QR code of the TOTP secret",
+		Tags:      []string{"2fa"},
+		Security:  "bearer",
+		Responses: map[int]any{http.StatusOK: openapi.Raw{"image/png"}},
+	})
+	spec.Add(gu.POST("/2fa/activate", handlers.Activate2FA), openapi.Op{
+		Summary:   "Enable 2FA with a first code",
+		Tags:      []string{"2fa"},
+		Security:  "bearer",
+		Body:      users.CodeInput{},
+		Responses: map[int]any{http.StatusOK: users.RecoveryOutput{}},
+	})
+	spec.Add(gu.DELETE("/2fa", handlers.Disable2FA), openapi.Op{
+		Summary:   "Disable 2FA",
+		Tags:      []string{"2fa"},
+		Security:  "bearer",
+		Body:      users.CodeInput{},
+		Responses: map[int]any{http.StatusNoContent: nil},
+	})
+	spec.Add(gu.POST("/email/verify", handlers.ResendVerification), openapi.Op{
+		Summary:   "Mail a new verify token",
+		Tags:      []string{"email"},
+		Security:  "bearer",
+		Responses: map[int]any{http.StatusAccepted: nil},
+	})
+	spec.Add(gu.POST("/import", handlers.ImportUsers), openapi.Op{
+		Summary:  "Import users from CSV, NDJSON or XML",
+		Tags:     []string{"users"},
+		Security: "bearer",
+		Query: []openapi.Param{
+			{Name: "format", Example: "", Description: "csv, ndjson or xml, instead of the content type"},
+			{Name: "mode", Example: "", Description: "transaction or best_effort"},
+			{Name: "dry_run", Example: false},
+		},
+		Body: openapi.Raw{"text/csv", "application/x-ndjson", "application/jsonl", echo.MIMEApplicationXML, echo.MIMETextXML},
+		Responses: map[int]any{
+			http.StatusOK:      users.ImportOutput{},
+			http.StatusCreated: users.ImportOutput{},
+		},
+	})
+	spec.Add(gu.GET("/export", handlers.ExportUsers), openapi.Op{
+		Summary:   "Export every user",
+		Tags:      []string{"users"},
+		Security:  "bearer",
+		Query:     []openapi.Param{{Name: "format", Example: "", Description: "csv, ndjson or xml"}},
+		Responses: map[int]any{http.StatusOK: openapi.Raw{"text/csv", "application/x-ndjson", echo.MIMEApplicationXML}},
+	})
+	spec.Add(gu.GET("", handlers.GetAllUsers), openapi.Op{
+		Summary:   "List users",
+		Tags:      []string{"users"},
+		Security:  "bearer",
+		Query:     listParams,
+		Responses: map[int]any{http.StatusOK: users.ListOutput{}},
+	})
+	spec.Add(gu.GET("/:id", handlers.GetOneUser), openapi.Op{
+		Summary:  "Get a user",
+		Tags:     []string{"users"},
+		Security: "bearer",
+		Responses: map[int]any{
+			http.StatusOK:          users.Output{},
+			http.StatusNotModified: nil,
+		},
+	})
+	spec.Add(gu.POST("", handlers.CreateUser), openapi.Op{
+		Summary:   "Create a user",
+		Tags:      []string{"users"},
+		Security:  "bearer",
+		Body:      users.Input{},
+		Responses: map[int]any{http.StatusCreated: users.Output{}},
+	})
+	spec.Add(gu.PUT("/:id", handlers.UpdateUser), openapi.Op{
+		Summary:   "Replace a user",
+		Tags:      []string{"users"},
+		Security:  "bearer",
+		Body:      users.UpdateInput{},
+		Responses: map[int]any{http.StatusOK: users.Output{}},
+	})
+	spec.Add(gu.PATCH("/:id", handlers.PatchUser), openapi.Op{
+		Summary:   "Change part of a user",
+		Tags:      []string{"users"},
+		Security:  "bearer",
+		Body:      users.PatchDoc{},
+		BodyTypes: []string{"application/merge-patch+json", echo.MIMEApplicationJSON},
+		Bodies:    map[string]any{"application/json-patch+json": []users.PatchOp{}},
+		Responses: map[int]any{http.StatusOK: users.Output{}},
+	})
+	spec.Add(gu.POST("/:id/password", handlers.ChangePassword), openapi.Op{
+		Summary:   "Change the own password",
+		Tags:      []string{"password"},
+		Security:  "bearer",
+		Body:      users.PasswordInput{},
+		Responses: map[int]any{http.StatusNoContent: nil},
+	})
+	spec.Add(gu.DELETE("/:id", handlers.DeleteUser), openapi.Op{
+		Summary:   "Delete a user",
+		Tags:      []string{"users"},
+		Security:  "bearer",
+		Query:     []openapi.Param{{Name: "purge", Example: false, Description: "Delete for good instead of soft"}},
+		Responses: map[int]any{http.StatusNoContent: nil},
+	})
+	spec.Add(gu.POST("/:id/restore", handlers.RestoreUser), openapi.Op{
+		Summary:   "Restore a soft deleted user",
+		Tags:      []string{"users"},
+		Security:  "bearer",
+		Responses: map[int]any{http.StatusOK: users.Output{}},
+	})
+
+	ga := gv.Group("/audit")
+	ga.Use(jwtAuth)
+	ga.Use(handlers.TwoFactorRequired)
+	spec.Add(ga.GET("", handlers.GetAudit), openapi.Op{
+		Summary:  "Search the audit log",
+		Tags:     []string{"audit"},
+		Security: "bearer",
+		Query: []openapi.Param{
+			{Name: "actor_id", Example: int64(0)},
+			{Name: "target_id", Example: int64(0)},
+			{Name: "action", Example: "", Description: "A trailing dot matches a family, like user."},
+			{Name: "since", Example: time.Time{}},
+			{Name: "until", Example: time.Time{}},
+			{Name: "before_id", Example: int64(0), Description: "Page back from the last event seen"},
+			{Name: "limit", Example: 0},
+		},
+		Responses:     map[int]any{http.StatusOK: []audit.Event{}},
+		ResponseTypes: []string{echo.MIMEApplicationJSON},
+	})
+	spec.Add(ga.GET("/verify", handlers.VerifyAudit), openapi.Op{
+		Summary:       "Check the hash chain of the audit log",
+		Tags:          []string{"audit"},
+		Security:      "bearer",
+		Responses:     map[int]any{http.StatusOK: audit.Verification{}},
+		ResponseTypes: []string{echo.MIMEApplicationJSON},
+	})
+
+	gr := gv.Group("/roles")
+	gr.Use(session.Middleware(sessions.NewCookieStore(config.SessionKey())))
+	spec.Add(gr.POST("/login", handlers.Login), openapi.Op{
+		Summary: "Start a session with name and password",
+		Tags:    []string{"roles"},
+		Body:    users.AuthInput{},
+		Responses: map[int]any{
+			http.StatusOK:       users.Output{},
+			http.StatusAccepted: users.ChallengeOutput{},
+		},
+	})
+	spec.Add(gr.POST("/login/2fa", handlers.Login2FA), openapi.Op{
+		Summary:   "Answer a 2FA challenge for a session",
+		Tags:      []string{"roles"},
+		Body:      users.ChallengeInput{},
+		Responses: map[int]any{http.StatusOK: users.Output{}},
+	})
+	spec.Add(gr.GET("", handlers.GetAllRoles), openapi.Op{
+		Summary:   "List users",
+		Tags:      []string{"roles"},
+		Security:  "session",
+		Query:     listParams,
+		Responses: map[int]any{http.StatusOK: users.ListOutput{}},
+	})
+	spec.Add(gr.GET("/:id", handlers.GetOneRole), openapi.Op{
+		Summary:  "Get a user",
+		Tags:     []string{"roles"},
+		Security: "session",
+		Responses: map[int]any{
+			http.StatusOK:          users.Output{},
+			http.StatusNotModified: nil,
+		},
+	})
+	spec.Add(gr.POST("", handlers.CreateRole), openapi.Op{
+		Summary:   "Create a user",
+		Tags:      []string{"roles"},
+		Security:  "session",
+		Body:      users.Input{},
+		Responses: map[int]any{http.StatusCreated: users.Output{}},
+	})
+	spec.Add(gr.PUT("/:id", handlers.UpdateRole), openapi.Op{
+		Summary:   "Replace a user",
+		Tags:      []string{"roles"},
+		Security:  "session",
+		Body:      users.UpdateInput{},
+		Responses: map[int]any{http.StatusOK: users.Output{}},
+	})
+	spec.Add(gr.DELETE("/:id", handlers.DeleteRole), openapi.Op{
+		Summary:   "Delete a user",
+		Tags:      []string{"roles"},
+		Security:  "session",
+		Query:     []openapi.Param{{Name: "purge", Example: false, Description: "Delete for good instead of soft"}},
+		Responses: map[int]any{http.StatusNoContent: nil},
+	})
+
+	return spec
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"echo-demo/config"
+	"echo-demo/handlers"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// Every route has to be documented, which is easiest at registration:
+// spec.Add(g.GET(...), openapi.Op{...}).
+func TestRoutesDocumented(t *testing.T) {
+	e := echo.New()
+	spec := routes(e)
+
+	for _, r := range e.Routes() {
+		if r.Method == echo.RouteNotFound {
+			continue
+		}
+		if spec.Operation(r.Method, r.Path) == nil {
+			t.Errorf("%s %s is missing from the OpenAPI spec", r.Method, r.Path)
+		}
+	}
+}
+
+func TestSpecValid(t *testing.T) {
+	spec := routes(echo.New())
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q, want 3.1.0", doc.OpenAPI)
+	}
+	if _, ok := doc.Paths["/v1/users/{id}"]["get"]; !ok {
+		t.Error("GET /v1/users/{id} is missing")
+	}
+}
+
+// newServer sets e up like main does, short of the database.
+func newServer(t *testing.T) *echo.Echo {
+	e := echo.New()
+	e.Validator = &CustomValidator{validator: newValidator()}
+	e.Binder = new(handlers.Binder)
+	e.HTTPErrorHandler = handlers.ErrorHandler
+	e.Use(handlers.RequestID)
+	if err := useMiddleware(e); err != nil {
+		t.Fatal(err)
+	}
+	spec := routes(e)
+	e.Use(spec.Validate)
+
+	return e
+}
+
+func adminToken(t *testing.T) string {
+	claims := &handlers.JwtCustomClaims{
+		ID:   1,
+		Name: "admin",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.SignKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Every format the handlers take has to get past the validation. The rows
+// lack a password, so that they fail before reaching the database.
+func TestBodiesAccepted(t *testing.T) {
+	e := newServer(t)
+	token := adminToken(t)
+
+	csv := "name,age\nbob,30\n"
+	ndjson := `{"name":"bob","age":30}` + "\n"
+	xml := "<users><user><name>bob</name><age>30</age></user></users>"
+	tests := []struct {
+		name   string
+		target string
+		ctype  string
+		body   string
+		status int
+		code   string
+	}{
+		{"csv", "/v1/users/import", "text/csv", csv, http.StatusOK, ""},
+		{"csv charset", "/v1/users/import", "text/csv; charset=utf-8", csv, http.StatusOK, ""},
+		{"x-ndjson", "/v1/users/import", "application/x-ndjson", ndjson, http.StatusOK, ""},
+		{"jsonl", "/v1/users/import", "application/jsonl", ndjson, http.StatusOK, ""},
+		{"xml", "/v1/users/import", "application/xml", xml, http.StatusOK, ""},
+		{"text xml", "/v1/users/import", "text/xml", xml, http.StatusOK, ""},
+		{"format csv", "/v1/users/import?format=csv", "text/plain", csv, http.StatusOK, ""},
+		{"format ndjson", "/v1/users/import?format=ndjson", "application/octet-stream", ndjson, http.StatusOK, ""},
+		{"format xml", "/v1/users/import?format=xml", "", xml, http.StatusOK, ""},
+		{"unknown", "/v1/users/import", "text/plain", csv, http.StatusUnsupportedMediaType, ""},
+		{"user text xml", "/v1/users", "text/xml", "<user></user>", http.StatusBadRequest, "validation_failed"},
+		{"user x-msgpack", "/v1/users", "application/x-msgpack", "\x80", http.StatusBadRequest, "validation_failed"},
+		{"user vnd.msgpack", "/v1/users", "application/vnd.msgpack", "\x80", http.StatusBadRequest, "validation_failed"},
+		{"user text plain", "/v1/users", "text/plain", "x", http.StatusUnsupportedMediaType, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if len(tt.ctype) > 0 {
+				req.Header.Set(echo.HeaderContentType, tt.ctype)
+			}
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if len(tt.code) > 0 {
+				var p handlers.Problem
+				if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+					t.Fatal(err)
+				}
+				if p.Code != tt.code {
+					t.Errorf("code = %q, want %q", p.Code, tt.code)
+				}
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"failed":1`) {
+				t.Errorf("body = %s, want one failed row", rec.Body)
+			}
+		})
+	}
+}
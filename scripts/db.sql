@@ -7,8 +7,78 @@ CREATE TABLE users (
   password	VARCHAR(255) NOT NULL,
   age		INT,
   reg_date	DATETIME NOT NULL,
+  totp_secret	VARCHAR(64),
+  totp_enabled	BOOLEAN NOT NULL DEFAULT FALSE,
+  totp_last_step	BIGINT,
+  email		VARCHAR(255),
+  email_verified	BOOLEAN NOT NULL DEFAULT FALSE,
+  version	BIGINT NOT NULL DEFAULT 1,
+  updated_at	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  deleted_at	DATETIME,
   PRIMARY KEY(`id`),
-  UNIQUE(`name`)
+  UNIQUE(`name`),
+  UNIQUE(`email`),
+  INDEX(`reg_date`),
+  INDEX(`age`),
+  INDEX(`deleted_at`),
+  FULLTEXT(`name`, `email`)
 );
 
+CREATE TABLE recovery_codes (
+  id		BIGINT AUTO_INCREMENT NOT NULL,
+  user_id	BIGINT NOT NULL,
+  code_hash	CHAR(64) NOT NULL,
+  used_at	DATETIME,
+  PRIMARY KEY(`id`),
+  INDEX(`user_id`),
+  FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE user_tokens (
+  id		BIGINT AUTO_INCREMENT NOT NULL,
+  user_id	BIGINT NOT NULL,
+  purpose	VARCHAR(16) NOT NULL,
+  token_hash	CHAR(64) NOT NULL,
+  data		VARCHAR(255) NOT NULL DEFAULT '',
+  expires_at	DATETIME NOT NULL,
+  used_at	DATETIME,
+  PRIMARY KEY(`id`),
+  UNIQUE(`token_hash`),
+  INDEX(`user_id`),
+  FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+);
+
+-- diff is TEXT rather than JSON, so that its bytes stay exactly as hashed.
+CREATE TABLE audit_log (
+  id		BIGINT AUTO_INCREMENT NOT NULL,
+  created_at	DATETIME(6) NOT NULL,
+  actor_id	BIGINT,
+  action	VARCHAR(64) NOT NULL,
+  target_id	BIGINT,
+  diff		TEXT,
+  ip		VARCHAR(64) NOT NULL,
+  request_id	VARCHAR(64) NOT NULL,
+  prev_hash	CHAR(64),
+  hash		CHAR(64),
+  PRIMARY KEY(`id`),
+  INDEX(`actor_id`),
+  INDEX(`target_id`),
+  INDEX(`action`),
+  INDEX(`created_at`)
+);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+-- The head of the hash chain. Writers lock its one row to take turns.
+CREATE TABLE audit_chain (
+  id		TINYINT NOT NULL,
+  hash		CHAR(64),
+  PRIMARY KEY(`id`)
+);
+
+INSERT INTO audit_chain(id, hash) VALUES(1, NULL);
+
 INSERT INTO users(name, password, reg_date) VALUES('admin', '$argon2id$v=19$m=65536,t=1,p=12$4qYJsiDikwKPTI2p9GRxDA$os2AZJCH2X0xf6BYI0FUYOm4CZuH/kk4bew+IyM96sg', now());
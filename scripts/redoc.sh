@@ -0,0 +1,9 @@
+#!/bin/sh
+# Fetches the Redoc bundle /docs.html loads into static/, so that the docs
+# are served from here and the CSP need not allow a CDN.
+set -e
+
+VERSION=2.1.5
+
+cd "$(dirname "$0")/.."
+curl -fsSL -o static/redoc.standalone.js "https://cdn.redoc.ly/redoc/v${VERSION}/bundles/redoc.standalone.js"
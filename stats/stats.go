@@ -2,6 +2,7 @@ package stats
 
 import (
 	"context"
+	"echo-demo/logging"
 	"echo-demo/vk"
 	"fmt"
 	"net/http"
@@ -12,6 +13,8 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+var log = logging.For("stats")
+
 type Stats struct {
 	Uptime   time.Time      `json:"uptime"`
 	Requests uint64         `json:"requests"`
@@ -46,10 +49,12 @@ func (s *Stats) Process(next echo.HandlerFunc) echo.HandlerFunc {
 		}
 
 		status := strconv.Itoa(c.Response().Status)
-		smu := fmt.Sprintf("%s %-6s:%s", status, c.Request().Method, c.Request().URL.String())
+		smu := fmt.Sprintf("%s %-6s:%s", status, c.Request().Method, logging.RedactURL(c.Request().URL))
 
+		// The request is over by the time the counters are written, but
+		// its log lines should still name it.
+		ctx := context.WithoutCancel(c.Request().Context())
 		go func() {
-			ctx := context.Background()
 			client := vk.Client()
 
 			for _, resp := range client.DoMulti(ctx,
@@ -57,7 +62,7 @@ func (s *Stats) Process(next echo.HandlerFunc) echo.HandlerFunc {
 				client.B().Hincrby().Key("Statuses").Field(status).Increment(1).Build(),
 				client.B().Hincrby().Key("URLs").Field(smu).Increment(1).Build()) {
 				if err := resp.Error(); err != nil {
-					c.Echo().Logger.Debug(err)
+					log.DebugContext(ctx, "counter update failed", "err", err)
 				}
 			}
 		}()
@@ -73,22 +78,22 @@ func (s *Stats) Process(next echo.HandlerFunc) echo.HandlerFunc {
 }
 
 func (s *Stats) Handler(c echo.Context) error {
-	ctx := context.Background()
+	ctx := c.Request().Context()
 	client := vk.Client()
 
 	requests, err := client.Do(ctx, client.B().Get().Key("Requests").Build()).AsInt64()
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "stats read failed", "err", err)
 		return err
 	}
 	statuses, err := client.Do(ctx, client.B().Hgetall().Key("Statuses").Build()).AsIntMap()
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "stats read failed", "err", err)
 		return err
 	}
 	urls, err := client.Do(ctx, client.B().Hgetall().Key("URLs").Build()).AsIntMap()
 	if err != nil {
-		c.Echo().Logger.Debug(err)
+		log.DebugContext(c.Request().Context(), "stats read failed", "err", err)
 		return err
 	}
 
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"echo-demo/certs"
+	"echo-demo/config"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clientCertUsers reads the name=id pairs of the config.
+func clientCertUsers() (map[string]int64, error) {
+	byName := map[string]int64{}
+	for _, pair := range config.ClientCertUsers() {
+		name, val, ok := strings.Cut(pair, "=")
+		id, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		if !ok || err != nil || id <= 0 {
+			return nil, fmt.Errorf("ClientCertUsers(%s) Invalid", pair)
+		}
+		byName[strings.TrimSpace(name)] = id
+	}
+	return byName, nil
+}
+
+// redirectHTTPS sends plain HTTP to the same URL on the port of tlsAddr,
+// keeping the method.
+func redirectHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if len(port) > 0 && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// gencert writes a self-signed certificate and key to try TLS locally:
+//
+//	echo-demo gencert -hosts localhost,127.0.0.1
+func gencert(args []string) error {
+	fs := flag.NewFlagSet("gencert", flag.ContinueOnError)
+	certFile := fs.String("cert", "./cert.pem", "certificate file")
+	keyFile := fs.String("key", "./key.pem", "key file")
+	name := fs.String("cn", "localhost", "common name, the user name for client certificates")
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "comma separated host names and IPs")
+	days := fs.Int("days", 365, "days of validity")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var names []string
+	for _, host := range strings.Split(*hosts, ",") {
+		if host = strings.TrimSpace(host); len(host) > 0 {
+			names = append(names, host)
+		}
+	}
+	validFor := time.Duration(*days) * 24 * time.Hour
+	if err := certs.Generate(*certFile, *keyFile, *name, names, validFor); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and %s\n", *certFile, *keyFile)
+	return nil
+}
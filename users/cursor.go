@@ -0,0 +1,109 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"echo-demo/config"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrCursorInvalid = errors.New("Users: Cursor Invalid")
+
+// cursor holds the sort key of the last row of a page. It is bound to the
+// sort order and the filters it was made for.
+type cursor struct {
+	Sort   string            `json:"s"`
+	Filter string            `json:"f"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// encodeCursor makes an opaque cursor pointing after u, signed so that
+// clients can not forge positions.
+func encodeCursor(q *Query, u *User) (string, error) {
+	c := cursor{Sort: q.sortSpec(), Filter: q.filterHash()}
+	for _, f := range q.sorting() {
+		var v any
+		switch f.Field {
+		case "id":
+			v = u.ID
+		case "name":
+			v = u.Name
+		case "age":
+			v = u.Age
+		case "reg_date":
+			v = u.RegDate
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		c.Values = append(c.Values, raw)
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(payload)), nil
+}
+
+// decodeCursor checks a cursor against q and returns its sort key values.
+func decodeCursor(q *Query, s string) ([]any, error) {
+	payloadStr, sigStr, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, ErrCursorInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadStr)
+	if err != nil {
+		return nil, ErrCursorInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigStr)
+	if err != nil || !hmac.Equal(sig, sign(payload)) {
+		return nil, ErrCursorInvalid
+	}
+
+	var c cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, ErrCursorInvalid
+	}
+	fields := q.sorting()
+	if c.Sort != q.sortSpec() || c.Filter != q.filterHash() || len(c.Values) != len(fields) {
+		return nil, ErrCursorInvalid
+	}
+
+	values := make([]any, 0, len(fields))
+	for i, f := range fields {
+		var err error
+		switch f.Field {
+		case "id", "age":
+			var v int64
+			err = json.Unmarshal(c.Values[i], &v)
+			values = append(values, v)
+		case "name":
+			var v string
+			err = json.Unmarshal(c.Values[i], &v)
+			values = append(values, v)
+		case "reg_date":
+			var v time.Time
+			err = json.Unmarshal(c.Values[i], &v)
+			values = append(values, v)
+		}
+		if err != nil {
+			return nil, ErrCursorInvalid
+		}
+	}
+
+	return values, nil
+}
+
+func sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, config.CursorKey())
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
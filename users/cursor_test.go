@@ -0,0 +1,98 @@
+package users
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustQuery(t *testing.T, raw string) *Query {
+	t.Helper()
+	q, err := ParseQuery(mustValues(t, raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	regDate := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	u := &User{ID: 42, Name: "alice", Age: 30, RegDate: regDate}
+
+	tests := []struct {
+		query string
+		want  []any
+	}{
+		{"", []any{int64(42)}},
+		{"sort=name", []any{"alice", int64(42)}},
+		{"sort=-age,name", []any{int64(30), "alice", int64(42)}},
+		{"sort=reg_date&name=al", []any{regDate, int64(42)}},
+		{"sort=id,name", []any{int64(42)}},
+	}
+	for _, tt := range tests {
+		q := mustQuery(t, tt.query)
+		s, err := encodeCursor(q, u)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		got, err := decodeCursor(mustQuery(t, tt.query), s)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: values = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// A cursor only fits the query it was made for, and only as it was made.
+func TestCursorRejected(t *testing.T) {
+	u := &User{ID: 42, Name: "alice", Age: 30}
+	made := "sort=name&age_min=18"
+	s, err := encodeCursor(mustQuery(t, made), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(s, ".")
+
+	forged := func(from, to string) string {
+		data, _ := base64.RawURLEncoding.DecodeString(payload)
+		data = []byte(strings.Replace(string(data), from, to, 1))
+		return base64.RawURLEncoding.EncodeToString(data) + "." + sig
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		cursor string
+	}{
+		{"no signature", made, payload},
+		{"bad base64", made, "!!." + sig},
+		{"other signature", made, payload + "." + base64.RawURLEncoding.EncodeToString([]byte("forged"))},
+		{"changed value", made, forged(`"alice"`, `"bob"`)},
+		{"changed sort", made, forged(`"name,id"`, `"-name,id"`)},
+		{"other sort", "sort=-name&age_min=18", s},
+		{"other filter", "sort=name&age_min=21", s},
+		{"filter dropped", "sort=name", s},
+		{"filter added", "sort=name&age_min=18&name=a", s},
+	}
+	for _, tt := range tests {
+		if _, err := decodeCursor(mustQuery(t, tt.query), tt.cursor); !errors.Is(err, ErrCursorInvalid) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, ErrCursorInvalid)
+		}
+	}
+}
+
+func TestFilterHashZones(t *testing.T) {
+	utc := mustQuery(t, "registered_after=2024-01-01T10:00:00Z")
+	cet := mustQuery(t, "registered_after=2024-01-01T11:00:00%2B01:00")
+	if utc.filterHash() != cet.filterHash() {
+		t.Error("the same instant in another zone hashes differently")
+	}
+	if utc.filterHash() == mustQuery(t, "registered_before=2024-01-01T10:00:00Z").filterHash() {
+		t.Error("after and before hash the same")
+	}
+}
@@ -1,28 +1,113 @@
 package users
 
 import (
+	"context"
 	"database/sql"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"echo-demo/audit"
 	"echo-demo/db"
+	"echo-demo/logging"
+	pwd "echo-demo/password"
+	"echo-demo/tracing"
 
-	"github.com/alexedwards/argon2id"
-	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel"
+)
+
+var (
+	log    = logging.For("users")
+	tracer = otel.Tracer("echo-demo/users")
 )
 
 type User struct {
-	ID       int64
-	Name     string
-	Password string
-	Age      int64
-	RegDate  time.Time
+	ID            int64
+	Name          string
+	Password      string
+	Age           int64
+	RegDate       time.Time
+	TOTPSecret    string
+	TOTPEnabled   bool
+	Email         string
+	EmailVerified bool
+	Version       int64
+	UpdatedAt     time.Time
+	DeletedAt     *time.Time
+}
+
+const userFields = "id, name, password, age, reg_date, totp_secret, totp_enabled, email, email_verified, version, updated_at, deleted_at"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row scanner) (u *User, err error) {
+	u = new(User)
+
+	var tmpAge sql.NullInt64
+	var tmpSecret, tmpEmail sql.NullString
+	var tmpDeleted sql.NullTime
+	if err := row.Scan(&u.ID, &u.Name, &u.Password, &tmpAge, &u.RegDate, &tmpSecret, &u.TOTPEnabled, &tmpEmail, &u.EmailVerified, &u.Version, &u.UpdatedAt, &tmpDeleted); err != nil {
+		return nil, err
+	}
+
+	if tmpAge.Valid {
+		u.Age = tmpAge.Int64
+	}
+	if tmpSecret.Valid {
+		u.TOTPSecret = tmpSecret.String
+	}
+	if tmpEmail.Valid {
+		u.Email = tmpEmail.String
+	}
+	if tmpDeleted.Valid {
+		u.DeletedAt = &tmpDeleted.Time
+	}
+
+	return u, nil
 }
 
+var ErrPasswordIncorrect = errors.New("Users: Password Incorrect")
+
 type Input struct {
-	Name     string `json:"name" form:"name" xml:"name" validate:"required"`
+	Name     string `json:"name" form:"name" xml:"name" validate:"required,max=128"`
 	Password string `json:"password" form:"password" xml:"password" validate:"required"`
 	Age      int64  `json:"age" form:"age" xml:"age"`
+	Email    string `json:"email" form:"email" xml:"email" validate:"omitempty,email,max=255"`
+}
+
+// UpdateInput replaces a user as a whole, except that an empty password
+// keeps the current one.
+type UpdateInput struct {
+	Name     string `json:"name" form:"name" xml:"name" validate:"required,max=128"`
+	Password string `json:"password" form:"password" xml:"password"`
+	Age      int64  `json:"age" form:"age" xml:"age"`
+	Email    string `json:"email" form:"email" xml:"email" validate:"omitempty,email,max=255"`
+}
+
+// PatchDoc is the document PATCH requests are applied to. The password
+// has an endpoint of its own.
+type PatchDoc struct {
+	Name  string `json:"name" validate:"required,max=128"`
+	Age   int64  `json:"age" validate:"gte=0"`
+	Email string `json:"email" validate:"omitempty,email,max=255"`
+}
+
+// PatchOp is one operation of a JSON Patch document, as the API docs show
+// it. Applying patches is left to the jsonpatch package.
+type PatchOp struct {
+	Op    string `json:"op" validate:"required,oneof=add remove replace move copy test"`
+	Path  string `json:"path" validate:"required"`
+	From  string `json:"from,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+type PasswordInput struct {
+	CurrentPassword string `json:"current_password" form:"current_password" xml:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" form:"new_password" xml:"new_password" validate:"required"`
 }
 
 type AuthInput struct {
@@ -31,19 +116,32 @@ type AuthInput struct {
 }
 
 type Output struct {
-	ID      int64     `json:"id"`
-	Name    string    `json:"name"`
-	Age     int64     `json:"age"`
-	RegDate time.Time `json:"reg_date"`
+	XMLName       xml.Name   `json:"-" xml:"user"`
+	ID            int64      `json:"id" xml:"id"`
+	Name          string     `json:"name" xml:"name"`
+	Age           int64      `json:"age" xml:"age"`
+	RegDate       time.Time  `json:"reg_date" xml:"reg_date"`
+	TwoFactor     bool       `json:"two_factor" xml:"two_factor"`
+	Email         string     `json:"email,omitempty" xml:"email,omitempty"`
+	EmailVerified bool       `json:"email_verified" xml:"email_verified"`
+	Version       int64      `json:"version" xml:"version"`
+	UpdatedAt     time.Time  `json:"updated_at" xml:"updated_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
 }
 
 type AuthOutput struct {
-	User  *Output `json:"user"`
-	Token string  `json:"token"`
+	XMLName xml.Name `json:"-" xml:"auth"`
+	User    *Output  `json:"user" xml:"user"`
+	Token   string   `json:"token" xml:"token"`
 }
 
-func NewOne(name string, password string, age int64, regDate time.Time) (uOut *Output, err error) {
-	u, err := newOne(name, password, age, regDate)
+func NewOne(ctx context.Context, name string, password string, age int64, email string, regDate time.Time) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.NewOne")
+	defer tracing.End(span, &err)
+
+	u, err := newOne(ctx, name, password, age, email, regDate)
 	if err != nil {
 		return nil, err
 	}
@@ -53,17 +151,23 @@ func NewOne(name string, password string, age int64, regDate time.Time) (uOut *O
 
 func toOut(u *User) *Output {
 	return &Output{
-		ID:      u.ID,
-		Name:    u.Name,
-		Age:     u.Age,
-		RegDate: u.RegDate,
+		ID:            u.ID,
+		Name:          u.Name,
+		Age:           u.Age,
+		RegDate:       u.RegDate,
+		TwoFactor:     u.TOTPEnabled,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Version:       u.Version,
+		UpdatedAt:     u.UpdatedAt,
+		DeletedAt:     u.DeletedAt,
 	}
 }
 
-func newOne(name string, password string, age int64, regDate time.Time) (u *User, err error) {
+// newOne returns the user as stored, read back in the same transaction.
+func newOne(ctx context.Context, name string, password string, age int64, email string, regDate time.Time) (u *User, err error) {
 	// Use Argon2 algorithms to generate password hashes
-	// Thanks Alex Edwards
-	hashPass, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	hashPass, err := pwd.Hash(ctx, password)
 	if err != nil {
 		return nil, err
 	}
@@ -74,38 +178,41 @@ func newOne(name string, password string, age int64, regDate time.Time) (u *User
 		tmpAge.Int64 = age
 	}
 
-	conn := db.Conn()
-	st, err := conn.Prepare("INSERT INTO users(name, password, age, reg_date) VALUES(?, ?, ?, ?)")
-	if err != nil {
-		return nil, err
-	}
-	defer st.Close()
-
-	result, err := st.Exec(name, hashPass, tmpAge, regDate)
-	if err != nil {
-		if e, ok := err.(*mysql.MySQLError); ok {
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "INSERT INTO users(name, password, age, email, reg_date, updated_at) VALUES(?, ?, ?, ?, ?, ?)",
+			name, hashPass, tmpAge, nullString(email), regDate, regDate)
+		if err != nil {
 			//Duplicate
-			if e.Number == 1062 {
-				return nil, db.ErrDupRows
+			if db.IsDuplicate(err) {
+				return db.ErrDupRows
 			}
+			return err
 		}
-		return nil, err
-	}
 
-	u = new(User)
-	u.ID, _ = result.LastInsertId()
-	u.Name = name
-	u.Password = hashPass
-	if age > 0 {
-		u.Age = age
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if u, err = getOneByID(ctx, tx, id); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, toOut(u))
+	})
+	if err != nil {
+		return nil, err
 	}
-	u.RegDate = regDate
 
 	return u, nil
 }
 
-func GetOneByID(id int64) (uOut *Output, err error) {
-	u, err := getOneByID(id)
+// GetOneByID reads from a replica, unless ctx has written already.
+func GetOneByID(ctx context.Context, id int64) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.GetOneByID")
+	defer tracing.End(span, &err)
+
+	u, err := getOneByID(ctx, db.Reader(ctx), id)
 	if err != nil {
 		return nil, err
 	}
@@ -113,60 +220,106 @@ func GetOneByID(id int64) (uOut *Output, err error) {
 	return toOut(u), nil
 }
 
-func getOneByID(id int64) (u *User, err error) {
-	u = new(User)
+func getOneByID(ctx context.Context, q db.Querier, id int64) (u *User, err error) {
+	return getOne(ctx, q, "id = ?", id)
+}
+
+// getOne reads the live user matching cond. Within a transaction the row
+// is locked for the rest of it.
+func getOne(ctx context.Context, q db.Querier, cond string, arg any) (u *User, err error) {
+	sqlStr := "SELECT " + userFields + " FROM users WHERE " + cond + " AND " + alive
+	if _, ok := q.(*sql.Tx); ok {
+		sqlStr += " FOR UPDATE"
+	}
 
-	conn := db.Conn()
-	st, err := conn.Prepare("SELECT id, name, password, age, reg_date FROM users WHERE id = ?")
+	st, err := q.PrepareContext(ctx, sqlStr)
 	if err != nil {
 		return nil, err
 	}
 	defer st.Close()
 
-	var tmpAge sql.NullInt64
-	if err := st.QueryRow(id).Scan(&u.ID, &u.Name, &u.Password, &tmpAge, &u.RegDate); err != nil {
+	u, err = scanUser(st.QueryRowContext(ctx, arg))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, db.ErrNotFound
 		}
 		return nil, err
 	}
 
-	if tmpAge.Valid {
-		u.Age = tmpAge.Int64
-	}
-
 	return u, nil
 }
 
-func GetAll(limit int64, offset int64) (uOuts []*Output, err error) {
-	us, err := getAll(limit, offset)
+type ListOutput struct {
+	XMLName    xml.Name  `json:"-" xml:"users"`
+	Items      []*Output `json:"items" xml:"user"`
+	NextCursor string    `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+	Total      *int64    `json:"total,omitempty" xml:"total,omitempty"`
+}
+
+// GetAll returns a page of at most limit users matching q, following the
+// position of cursor when it is not empty. The total count costs an extra
+// query and is only made on request. Like GetOneByID, it reads from a
+// replica when it can.
+func GetAll(ctx context.Context, q *Query, limit int64, cursor string, withTotal bool) (lOut *ListOutput, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.GetAll")
+	defer tracing.End(span, &err)
+
+	var after []any
+	if len(cursor) > 0 {
+		if after, err = decodeCursor(q, cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	// One more row than asked for tells whether there is a next page.
+	us, err := getAll(ctx, q, limit+1, after)
 	if err != nil {
 		return nil, err
 	}
 
-	uOuts = make([]*Output, 0, limit)
+	lOut = &ListOutput{Items: make([]*Output, 0, limit)}
+	if int64(len(us)) > limit {
+		us = us[:limit]
+		if lOut.NextCursor, err = encodeCursor(q, us[len(us)-1]); err != nil {
+			return nil, err
+		}
+	}
 	for _, u := range us {
-		uOuts = append(uOuts, toOut(u))
+		lOut.Items = append(lOut.Items, toOut(u))
+	}
+
+	if withTotal {
+		total, err := count(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		lOut.Total = &total
 	}
 
-	return uOuts, nil
+	return lOut, nil
 }
 
-func getAll(limit int64, offset int64) (us []*User, err error) {
-	sqlStr := "SELECT id, name, password, age, reg_date FROM users"
-	sqlStr += " LIMIT " + fmt.Sprintf("%d", limit)
-	if offset > 0 {
-		sqlStr += " OFFSET " + fmt.Sprintf("%d", offset)
+func getAll(ctx context.Context, q *Query, limit int64, after []any) (us []*User, err error) {
+	conds, args := q.conds()
+	if after != nil {
+		cond, afterArgs := q.after(after)
+		conds = append(conds, cond)
+		args = append(args, afterArgs...)
 	}
 
-	conn := db.Conn()
-	st, err := conn.Prepare(sqlStr)
+	sqlStr := "SELECT " + userFields + " FROM users" + where(conds) + q.order()
+	sqlStr += " LIMIT " + fmt.Sprintf("%d", limit)
+
+	conn := db.Reader(ctx)
+	st, err := conn.PrepareContext(ctx, sqlStr)
 	if err != nil {
 		return nil, err
 	}
 	defer st.Close()
 
-	rows, err := st.Query()
+	rows, err := st.QueryContext(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -174,14 +327,10 @@ func getAll(limit int64, offset int64) (us []*User, err error) {
 
 	us = make([]*User, 0, limit)
 	for rows.Next() {
-		u := new(User)
-		var tmpAge sql.NullInt64
-		if err := rows.Scan(&u.ID, &u.Name, &u.Password, &tmpAge, &u.RegDate); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		if tmpAge.Valid {
-			u.Age = tmpAge.Int64
-		}
 		us = append(us, u)
 	}
 	if err := rows.Err(); err != nil {
@@ -191,85 +340,303 @@ func getAll(limit int64, offset int64) (us []*User, err error) {
 	return us, nil
 }
 
-func UpdateOne(id int64, name string, password string, age int64) (uOut *Output, err error) {
-	u, err := updateOne(id, name, password, age)
+func count(ctx context.Context, q *Query) (total int64, err error) {
+	conds, args := q.conds()
+
+	conn := db.Reader(ctx)
+	st, err := conn.PrepareContext(ctx, "SELECT COUNT(*) FROM users"+where(conds))
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	defer st.Close()
+
+	if err := st.QueryRowContext(ctx, args...).Scan(&total); err != nil {
+		return 0, err
 	}
 
-	return toOut(u), nil
+	return total, nil
 }
 
-func updateOne(id int64, name string, password string, age int64) (u *User, err error) {
-	hashPass, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+func where(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// Changes holds the fields of an update. Nil fields are left as they are.
+type Changes struct {
+	Name     *string
+	Password *string
+	Age      *int64
+	Email    *string
+}
+
+// UpdateOne applies ch only if the user is still at version, which makes
+// concurrent edits fail with db.ErrVersion. A version of 0 skips the check.
+func UpdateOne(ctx context.Context, id int64, ch *Changes, version int64) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.UpdateOne")
+	defer tracing.End(span, &err)
+
+	var u *User
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		if u, err = updateOne(ctx, tx, id, ch, version); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, toOut(u))
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	conn := db.Conn()
-	st, err := conn.Prepare("UPDATE users SET name = ?, password = ?, age = ? WHERE id = ?")
+	return toOut(u), nil
+}
+
+// updateOne locks the user, checks its version and writes ch, all within
+// tx, and returns the row as written.
+func updateOne(ctx context.Context, tx *sql.Tx, id int64, ch *Changes, version int64) (u *User, err error) {
+	u, err = getOneByID(ctx, tx, id)
 	if err != nil {
 		return nil, err
 	}
-	defer st.Close()
+	if version > 0 && u.Version != version {
+		return nil, db.ErrVersion
+	}
 
-	tmpAge := sql.NullInt64{}
-	if age > 0 {
-		tmpAge.Valid = true
-		tmpAge.Int64 = age
+	var sets []string
+	var args []any
+
+	if ch.Email != nil {
+		// email_verified is assigned first, while email still has the old value.
+		tmpEmail := nullString(*ch.Email)
+		sets = append(sets, "email_verified = (email_verified AND email <=> ?)", "email = ?")
+		args = append(args, tmpEmail, tmpEmail)
 	}
-	//result, err := st.Exec(name, hashPass, tmpAge, id)
-	_, err = st.Exec(name, hashPass, tmpAge, id)
-	if err != nil {
-		if e, ok := err.(*mysql.MySQLError); ok {
-			//Duplicate
-			if e.Number == 1062 {
-				return nil, db.ErrDupRows
-			}
+	if ch.Name != nil {
+		sets = append(sets, "name = ?")
+		args = append(args, *ch.Name)
+	}
+	if ch.Password != nil {
+		hashPass, err := pwd.Hash(ctx, *ch.Password)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, "password = ?")
+		args = append(args, hashPass)
+	}
+	if ch.Age != nil {
+		tmpAge := sql.NullInt64{}
+		if *ch.Age > 0 {
+			tmpAge.Valid = true
+			tmpAge.Int64 = *ch.Age
+		}
+		sets = append(sets, "age = ?")
+		args = append(args, tmpAge)
+	}
+
+	if len(sets) == 0 {
+		return u, nil
+	}
+
+	sets = append(sets, "version = version + 1", "updated_at = ?")
+	args = append(args, time.Now(), id)
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+		//Duplicate
+		if db.IsDuplicate(err) {
+			return nil, db.ErrDupRows
 		}
 		return nil, err
 	}
-	//num == 0 means id not found or data unchanged.
-	/*
-		if num, _ := result.RowsAffected(); num == 0 {
-			return nil, sql.ErrNoRows
+
+	return getOneByID(ctx, tx, id)
+}
+
+// ChangePassword sets a new password after checking the current one. The
+// row stays locked in between, so that a concurrent change can not slip in.
+func ChangePassword(ctx context.Context, id int64, current string, password string) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.ChangePassword")
+	defer tracing.End(span, &err)
+
+	var u *User
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		u, err = getOneByID(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		match, _, err := pwd.Verify(ctx, current, u.Password)
+		if err != nil {
+			return err
+		}
+		if !match {
+			return ErrPasswordIncorrect
 		}
-	*/
 
-	u, err = getOneByID(id)
+		if u, err = updateOne(ctx, tx, id, &Changes{Password: &password}, 0); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, toOut(u))
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return u, nil
+	return toOut(u), nil
+}
+
+// Soft deleted users are hidden from everything but restore and purge.
+const alive = "deleted_at IS NULL"
+
+// DeleteOne soft deletes the user if it is still at version, 0 skips the
+// check. The name stays taken until the user is purged.
+func DeleteOne(ctx context.Context, id int64, version int64) (err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.DeleteOne")
+	defer tracing.End(span, &err)
+
+	return db.WithTx(ctx, func(tx *sql.Tx) error {
+		u, err := getOneByID(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if version > 0 && u.Version != version {
+			return db.ErrVersion
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at = ?, version = version + 1 WHERE id = ?", time.Now(), id); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, nil)
+	})
 }
 
-func DeleteOne(id int64) error {
-	conn := db.Conn()
-	st, err := conn.Prepare("DELETE FROM users WHERE id = ?")
+func RestoreOne(ctx context.Context, id int64) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.RestoreOne")
+	defer tracing.End(span, &err)
+
+	var u *User
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL", id)
+		if err != nil {
+			return err
+		}
+		if num, _ := result.RowsAffected(); num == 0 {
+			return db.ErrNotFound
+		}
+
+		if u, err = getOneByID(ctx, tx, id); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, toOut(u))
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer st.Close()
 
-	result, err := st.Exec(id)
+	return toOut(u), nil
+}
+
+// PurgeOne hard deletes the user, deleted or not. A version above 0 must
+// match the stored one.
+func PurgeOne(ctx context.Context, id int64, version int64) (err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.PurgeOne")
+	defer tracing.End(span, &err)
+
+	return db.WithTx(ctx, func(tx *sql.Tx) error {
+		query, args := "DELETE FROM users WHERE id = ?", []any{id}
+		if version > 0 {
+			query, args = query+" AND version = ?", append(args, version)
+		}
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if num, _ := result.RowsAffected(); num == 0 {
+			if version > 0 {
+				var found int64
+				err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = ?", id).Scan(&found)
+				if err == nil {
+					return db.ErrVersion
+				}
+				if err != sql.ErrNoRows {
+					return err
+				}
+			}
+			return db.ErrNotFound
+		}
+		return audit.Commit(ctx, tx, id, nil)
+	})
+}
+
+// Purge hard deletes the users soft deleted before the given time, with
+// an audit record each when ctx carries one.
+func Purge(ctx context.Context, before time.Time) (num int64, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.Purge")
+	defer tracing.End(span, &err)
+
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		num = 0
+		rows, err := tx.QueryContext(ctx, "SELECT id FROM users WHERE deleted_at < ? FOR UPDATE", before)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id); err != nil {
+				return err
+			}
+			if err := audit.Commit(ctx, tx, id, nil); err != nil {
+				return err
+			}
+			num++
+		}
+		return nil
+	})
 	if err != nil {
-		return err
-	}
-	if num, _ := result.RowsAffected(); num == 0 {
-		return db.ErrNotFound
+		return 0, err
 	}
 
-	return nil
+	return num, nil
 }
 
-func Auth(name string, password string) (uOut *Output, err error) {
-	u, err := getOneByName(name)
+func Auth(ctx context.Context, name string, password string) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.Auth")
+	defer tracing.End(span, &err)
+
+	u, err := getOne(ctx, db.Conn(), "name = ?", name)
 	if err != nil {
 		return nil, err
 	}
 
-	match, err := argon2id.ComparePasswordAndHash(password, u.Password)
+	match, rehash, err := pwd.Verify(ctx, password, u.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -278,30 +645,56 @@ func Auth(name string, password string) (uOut *Output, err error) {
 		return nil, db.ErrNotFound
 	}
 
+	if rehash {
+		// The login has already succeeded, a failed upgrade is retried
+		// next time.
+		if err := upgradeHash(ctx, u, password); err != nil {
+			log.WarnContext(ctx, "hash upgrade failed", "id", u.ID, "err", err)
+		} else {
+			log.InfoContext(ctx, "hash upgraded", "id", u.ID)
+		}
+	}
+
 	return toOut(u), nil
 }
 
-func getOneByName(name string) (u *User, err error) {
-	u = new(User)
+// upgradeHash replaces a legacy or weak hash, unless the password has been
+// changed in the meantime.
+func upgradeHash(ctx context.Context, u *User, password string) error {
+	hashPass, err := pwd.Hash(ctx, password)
+	if err != nil {
+		return err
+	}
 
-	conn := db.Conn()
-	st, err := conn.Prepare("SELECT id, name, password, age, reg_date FROM users WHERE name = ?")
+	conn := db.Writer(ctx)
+	st, err := conn.PrepareContext(ctx, "UPDATE users SET password = ? WHERE id = ? AND password = ?")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer st.Close()
 
-	var tmpAge sql.NullInt64
-	if err := st.QueryRow(name).Scan(&u.ID, &u.Name, &u.Password, &tmpAge, &u.RegDate); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, db.ErrNotFound
-		}
-		return nil, err
+	if _, err := st.ExecContext(ctx, hashPass, u.ID, u.Password); err != nil {
+		return err
 	}
+	u.Password = hashPass
 
-	if tmpAge.Valid {
-		u.Age = tmpAge.Int64
+	return nil
+}
+
+func GetOneByEmail(ctx context.Context, email string) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.GetOneByEmail")
+	defer tracing.End(span, &err)
+
+	u, err := getOne(ctx, db.Conn(), "email = ?", email)
+	if err != nil {
+		return nil, err
 	}
 
-	return u, nil
+	return toOut(u), nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: len(s) > 0}
 }
@@ -0,0 +1,250 @@
+package users
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrQueryInvalid = errors.New("Users: Query Invalid")
+
+// Sortable fields, mapped to their columns. Nothing outside this list ever
+// reaches the ORDER BY clause.
+var sortColumns = map[string]string{
+	"id":       "id",
+	"name":     "name",
+	"age":      "COALESCE(age, 0)",
+	"reg_date": "reg_date",
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Query is the user list query language. It is parsed from the request
+// independently of the storage, and compiled by each backend.
+type Query struct {
+	NamePrefix       string
+	NameContains     string
+	AgeMin           *int64
+	AgeMax           *int64
+	RegisteredAfter  *time.Time
+	RegisteredBefore *time.Time
+	Search           string
+	Sort             []SortField
+	IncludeDeleted   bool
+}
+
+// ParseQuery reads name, name_contains, age_min, age_max, registered_after,
+// registered_before, q and sort=field,-field. Whether deleted users are
+// included is up to the caller.
+func ParseQuery(params url.Values) (q *Query, err error) {
+	q = &Query{
+		NamePrefix:   params.Get("name"),
+		NameContains: params.Get("name_contains"),
+		Search:       strings.TrimSpace(params.Get("q")),
+	}
+
+	if q.AgeMin, err = parseInt(params, "age_min"); err != nil {
+		return nil, err
+	}
+	if q.AgeMax, err = parseInt(params, "age_max"); err != nil {
+		return nil, err
+	}
+	if q.RegisteredAfter, err = parseTime(params, "registered_after"); err != nil {
+		return nil, err
+	}
+	if q.RegisteredBefore, err = parseTime(params, "registered_before"); err != nil {
+		return nil, err
+	}
+
+	if sort := params.Get("sort"); len(sort) > 0 {
+		seen := map[string]bool{}
+		for _, f := range strings.Split(sort, ",") {
+			f = strings.TrimSpace(f)
+			desc := strings.HasPrefix(f, "-")
+			f = strings.TrimPrefix(strings.TrimPrefix(f, "-"), "+")
+			if _, ok := sortColumns[f]; !ok || seen[f] {
+				return nil, fmt.Errorf("%w: sort field %q", ErrQueryInvalid, f)
+			}
+			seen[f] = true
+			q.Sort = append(q.Sort, SortField{Field: f, Desc: desc})
+		}
+	}
+
+	return q, nil
+}
+
+func parseInt(params url.Values, key string) (*int64, error) {
+	val := params.Get(key)
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	num, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrQueryInvalid, key)
+	}
+
+	return &num, nil
+}
+
+// parseTime accepts RFC 3339 timestamps and plain dates.
+func parseTime(params url.Values, key string) (*time.Time, error) {
+	val := params.Get(key)
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, val, time.Local); err == nil {
+			return &t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrQueryInvalid, key)
+}
+
+// sorting is the full order of the query: the requested fields, with id
+// breaking ties so that the order is always fully defined. Nothing after
+// id can change the order any more.
+func (q *Query) sorting() []SortField {
+	fields := make([]SortField, 0, len(q.Sort)+1)
+	for _, f := range q.Sort {
+		fields = append(fields, f)
+		if f.Field == "id" {
+			return fields
+		}
+	}
+
+	return append(fields, SortField{Field: "id"})
+}
+
+// sortSpec is the canonical form of sorting, which a cursor is bound to.
+func (q *Query) sortSpec() string {
+	fields := q.sorting()
+	spec := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Desc {
+			spec = append(spec, "-"+f.Field)
+		} else {
+			spec = append(spec, f.Field)
+		}
+	}
+
+	return strings.Join(spec, ",")
+}
+
+// filterHash sums up the filters in a canonical form, which a cursor is
+// bound to as well. Times count by the instant, not the zone.
+func (q *Query) filterHash() string {
+	utc := func(t *time.Time) *time.Time {
+		if t == nil {
+			return nil
+		}
+		u := t.UTC()
+		return &u
+	}
+
+	data, _ := json.Marshal([]any{
+		q.NamePrefix,
+		q.NameContains,
+		q.AgeMin,
+		q.AgeMax,
+		utc(q.RegisteredAfter),
+		utc(q.RegisteredBefore),
+		q.Search,
+		q.IncludeDeleted,
+	})
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// conds compiles the filters for MySQL. Every value is passed as an
+// argument.
+func (q *Query) conds() (conds []string, args []any) {
+	if !q.IncludeDeleted {
+		conds = append(conds, alive)
+	}
+	if len(q.NamePrefix) > 0 {
+		conds = append(conds, "name LIKE ?")
+		args = append(args, escapeLike(q.NamePrefix)+"%")
+	}
+	if len(q.NameContains) > 0 {
+		conds = append(conds, "name LIKE ?")
+		args = append(args, "%"+escapeLike(q.NameContains)+"%")
+	}
+	if q.AgeMin != nil {
+		conds = append(conds, "age >= ?")
+		args = append(args, *q.AgeMin)
+	}
+	if q.AgeMax != nil {
+		conds = append(conds, "age <= ?")
+		args = append(args, *q.AgeMax)
+	}
+	if q.RegisteredAfter != nil {
+		conds = append(conds, "reg_date >= ?")
+		args = append(args, *q.RegisteredAfter)
+	}
+	if q.RegisteredBefore != nil {
+		conds = append(conds, "reg_date < ?")
+		args = append(args, *q.RegisteredBefore)
+	}
+	if len(q.Search) > 0 {
+		conds = append(conds, "MATCH(name, email) AGAINST(? IN BOOLEAN MODE)")
+		args = append(args, q.Search)
+	}
+
+	return conds, args
+}
+
+func (q *Query) order() string {
+	fields := q.sorting()
+	orders := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Desc {
+			orders = append(orders, sortColumns[f.Field]+" DESC")
+		} else {
+			orders = append(orders, sortColumns[f.Field]+" ASC")
+		}
+	}
+
+	return " ORDER BY " + strings.Join(orders, ", ")
+}
+
+// after compiles the keyset condition for the rows following values in
+// the query order: (f1 > v1) OR (f1 = v1 AND f2 > v2) OR ..., with < for
+// descending fields.
+func (q *Query) after(values []any) (cond string, args []any) {
+	fields := q.sorting()
+	ors := make([]string, 0, len(fields))
+	for i, f := range fields {
+		ands := make([]string, 0, i+1)
+		for _, prev := range fields[:i] {
+			ands = append(ands, sortColumns[prev.Field]+" = ?")
+		}
+		args = append(args, values[:i]...)
+
+		op := " > ?"
+		if f.Desc {
+			op = " < ?"
+		}
+		ands = append(ands, sortColumns[f.Field]+op)
+		args = append(args, values[i])
+
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
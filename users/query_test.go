@@ -0,0 +1,125 @@
+package users
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustValues(t *testing.T, raw string) url.Values {
+	t.Helper()
+	params, err := url.ParseQuery(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+// Only the listed fields reach ORDER BY, each once.
+func TestParseQuerySort(t *testing.T) {
+	tests := []struct {
+		sort  string
+		order string
+		err   bool
+	}{
+		{"", " ORDER BY id ASC", false},
+		{"name", " ORDER BY name ASC, id ASC", false},
+		{"-age,+name", " ORDER BY COALESCE(age, 0) DESC, name ASC, id ASC", false},
+		{"-reg_date", " ORDER BY reg_date DESC, id ASC", false},
+		{"id,name", " ORDER BY id ASC", false},
+		{" -id ", " ORDER BY id DESC", false},
+		{"password", "", true},
+		{"email", "", true},
+		{"name;DROP TABLE users", "", true},
+		{"name DESC", "", true},
+		{"COALESCE(age, 0)", "", true},
+		{"name,name", "", true},
+		{"name,-name", "", true},
+		{",", "", true},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(map[string][]string{"sort": {tt.sort}})
+		if tt.err {
+			if !errors.Is(err, ErrQueryInvalid) {
+				t.Errorf("%q: err = %v, want %v", tt.sort, err, ErrQueryInvalid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.sort, err)
+			continue
+		}
+		if got := q.order(); got != tt.order {
+			t.Errorf("%q: order = %q, want %q", tt.sort, got, tt.order)
+		}
+	}
+}
+
+func TestParseQueryFilters(t *testing.T) {
+	tests := []struct {
+		query string
+		err   bool
+	}{
+		{"age_min=18&age_max=65", false},
+		{"registered_after=2024-01-01&registered_before=2024-02-01T00:00:00Z", false},
+		{"age_min=eighteen", true},
+		{"age_max=1.5", true},
+		{"registered_after=yesterday", true},
+		{"registered_before=2024-13-01", true},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(mustValues(t, tt.query))
+		if got := err != nil; got != tt.err || (tt.err && !errors.Is(err, ErrQueryInvalid)) {
+			t.Errorf("%q: err = %v, want error %v", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"alice", "alice"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`back\slash`, `back\\slash`},
+		{`%_\`, `\%\_\\`},
+		{`\%`, `\\\%`},
+	}
+	for _, tt := range tests {
+		if got := escapeLike(tt.in); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Filters become placeholders, never part of the SQL.
+func TestConds(t *testing.T) {
+	q, err := ParseQuery(mustValues(t, "name=a_%25&name_contains='%3B --&age_min=18&q=bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conds, args := q.conds()
+	wantConds := []string{alive, "name LIKE ?", "name LIKE ?", "age >= ?", "MATCH(name, email) AGAINST(? IN BOOLEAN MODE)"}
+	wantArgs := []any{`a\_\%%`, `%'; --%`, int64(18), "bob"}
+	if !reflect.DeepEqual(conds, wantConds) {
+		t.Errorf("conds = %q, want %q", conds, wantConds)
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %q, want %q", args, wantArgs)
+	}
+	for _, c := range conds {
+		if strings.Contains(c, "bob") || strings.Contains(c, "--") {
+			t.Errorf("value in condition %q", c)
+		}
+	}
+
+	q.IncludeDeleted = true
+	if conds, _ := q.conds(); conds[0] == alive {
+		t.Error("deleted users are still left out")
+	}
+}
@@ -0,0 +1,192 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"echo-demo/audit"
+	"echo-demo/db"
+	pwd "echo-demo/password"
+	"echo-demo/tracing"
+)
+
+var ErrTokenInvalid = errors.New("Users: Token Invalid")
+
+const (
+	PurposeReset  = "reset"
+	PurposeVerify = "verify"
+)
+
+type ForgotInput struct {
+	Email string `json:"email" form:"email" xml:"email" validate:"required,email"`
+}
+
+type ResetInput struct {
+	Token    string `json:"token" form:"token" xml:"token" validate:"required"`
+	Password string `json:"password" form:"password" xml:"password" validate:"required"`
+}
+
+type VerifyInput struct {
+	Token string `json:"token" form:"token" query:"token" xml:"token" validate:"required"`
+}
+
+// NewToken issues a single-use token for the user. Only its hash is kept,
+// data is bound to the token and checked again when it is used.
+func NewToken(ctx context.Context, id int64, purpose string, data string, ttl time.Duration) (token string, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.NewToken")
+	defer tracing.End(span, &err)
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+
+	conn := db.Writer(ctx)
+	st, err := conn.PrepareContext(ctx, "INSERT INTO user_tokens(user_id, purpose, token_hash, data, expires_at) VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		return "", err
+	}
+	defer st.Close()
+
+	if _, err := st.ExecContext(ctx, id, purpose, hashToken(token), data, time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// TokenOwner returns the user of a valid token without using it up.
+func TokenOwner(ctx context.Context, token string, purpose string) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.TokenOwner")
+	defer tracing.End(span, &err)
+
+	conn := db.Conn()
+	st, err := conn.PrepareContext(ctx, "SELECT user_id FROM user_tokens WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?")
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	var id int64
+	if err := st.QueryRowContext(ctx, hashToken(token), purpose, time.Now()).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+
+	return GetOneByID(ctx, id)
+}
+
+// useToken marks a valid token as used within tx and returns its owner
+// and data.
+func useToken(ctx context.Context, tx *sql.Tx, token string, purpose string) (id int64, data string, err error) {
+	row := tx.QueryRowContext(ctx, "SELECT user_id, data FROM user_tokens WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ? FOR UPDATE",
+		hashToken(token), purpose, time.Now())
+	if err := row.Scan(&id, &data); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", ErrTokenInvalid
+		}
+		return 0, "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE token_hash = ?", time.Now(), hashToken(token)); err != nil {
+		return 0, "", err
+	}
+
+	return id, data, nil
+}
+
+// ResetPassword sets a new password with a reset token. Every other reset
+// token of the user is spent as well.
+func ResetPassword(ctx context.Context, token string, password string) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.ResetPassword")
+	defer tracing.End(span, &err)
+
+	hashPass, err := pwd.Hash(ctx, password)
+	if err != nil {
+		return nil, err
+	}
+
+	var u *User
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		id, _, err := useToken(ctx, tx, token, PurposeReset)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET password = ?, version = version + 1, updated_at = ? WHERE id = ?", hashPass, time.Now(), id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL", time.Now(), id, PurposeReset); err != nil {
+			return err
+		}
+
+		if u, err = getOneByID(ctx, tx, id); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, toOut(u))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toOut(u), nil
+}
+
+// VerifyEmail marks the email address a verify token was sent to as
+// verified, as long as the user still has that address.
+func VerifyEmail(ctx context.Context, token string) (uOut *Output, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.VerifyEmail")
+	defer tracing.End(span, &err)
+
+	var u *User
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		id, email, err := useToken(ctx, tx, token, PurposeVerify)
+		if err != nil {
+			return err
+		}
+
+		u, err = getOneByID(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if u.Email != email {
+			return ErrTokenInvalid
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET email_verified = TRUE, version = version + 1, updated_at = ? WHERE id = ?", time.Now(), id); err != nil {
+			return err
+		}
+
+		if u, err = getOneByID(ctx, tx, id); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, toOut(u))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toOut(u), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
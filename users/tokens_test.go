@@ -0,0 +1,99 @@
+package users
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"echo-demo/db/dbtest"
+)
+
+func TestHashToken(t *testing.T) {
+	a, b := "dGhlIHRva2Vu", "b3RoZXIgdG9rZW4"
+	if hashToken(a) != hashToken(a) {
+		t.Error("the same token hashes differently")
+	}
+	if hashToken(a) == hashToken(b) {
+		t.Error("different tokens hash the same")
+	}
+	if h := hashToken(a); len(h) != 64 || strings.Contains(h, a) {
+		t.Errorf("hash %q is not a hex SHA-256 of the token", h)
+	}
+}
+
+type tokenRow struct {
+	userID  int64
+	purpose string
+	data    string
+	expires time.Time
+	used    bool
+}
+
+// tokenDB keeps tokens by hash, like user_tokens.
+func tokenDB(tokens map[string]*tokenRow) *dbtest.DB {
+	return &dbtest.DB{
+		Query: map[string]func([]driver.Value) [][]driver.Value{
+			"SELECT user_id, data FROM user_tokens WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ? FOR UPDATE": func(args []driver.Value) [][]driver.Value {
+				row, ok := tokens[args[0].(string)]
+				if !ok || row.purpose != args[1].(string) || row.used || !row.expires.After(args[2].(time.Time)) {
+					return nil
+				}
+				return [][]driver.Value{{row.userID, row.data}}
+			},
+		},
+		Exec: map[string]func([]driver.Value) (int64, error){
+			"UPDATE user_tokens SET used_at = ? WHERE token_hash = ?": func(args []driver.Value) (int64, error) {
+				row, ok := tokens[args[1].(string)]
+				if !ok {
+					return 0, nil
+				}
+				row.used = true
+				return 1, nil
+			},
+		},
+	}
+}
+
+// Tokens are looked up by hash only, for their purpose, and work once.
+func TestUseToken(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+	tokens := map[string]*tokenRow{
+		hashToken("reset"):   {userID: 1, purpose: PurposeReset, expires: later},
+		hashToken("verify"):  {userID: 2, purpose: PurposeVerify, data: "bob@example.com", expires: later},
+		hashToken("expired"): {userID: 3, purpose: PurposeReset, expires: time.Now().Add(-time.Minute)},
+	}
+	conn := tokenDB(tokens).Open(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		token   string
+		purpose string
+		id      int64
+		data    string
+		err     error
+	}{
+		{"reset", "reset", PurposeReset, 1, "", nil},
+		{"reset again", "reset", PurposeReset, 0, "", ErrTokenInvalid},
+		{"other purpose", "verify", PurposeReset, 0, "", ErrTokenInvalid},
+		{"verify", "verify", PurposeVerify, 2, "bob@example.com", nil},
+		{"verify again", "verify", PurposeVerify, 0, "", ErrTokenInvalid},
+		{"expired", "expired", PurposeReset, 0, "", ErrTokenInvalid},
+		{"unknown", "unknown", PurposeReset, 0, "", ErrTokenInvalid},
+		{"hash for token", hashToken("reset"), PurposeReset, 0, "", ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, data, err := useToken(ctx, tx, tt.token, tt.purpose)
+		tx.Commit()
+		if !errors.Is(err, tt.err) || id != tt.id || data != tt.data {
+			t.Errorf("%s: got %d, %q, %v, want %d, %q, %v", tt.name, id, data, err, tt.id, tt.data, tt.err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+
+	"echo-demo/audit"
+	"echo-demo/db"
+	pwd "echo-demo/password"
+	"echo-demo/tracing"
+)
+
+type ImportRow struct {
+	Row   int
+	Input *Input
+}
+
+type ImportResult struct {
+	Row   int    `json:"row" xml:"row"`
+	Name  string `json:"name" xml:"name"`
+	ID    int64  `json:"id,omitempty" xml:"id,omitempty"`
+	Error string `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+type ImportOutput struct {
+	XMLName   xml.Name        `json:"-" xml:"import"`
+	DryRun    bool            `json:"dry_run" xml:"dry_run"`
+	Atomic    bool            `json:"atomic" xml:"atomic"`
+	Committed bool            `json:"committed" xml:"committed"`
+	Created   int             `json:"created" xml:"created"`
+	Failed    int             `json:"failed" xml:"failed"`
+	Rows      []*ImportResult `json:"rows" xml:"result"`
+}
+
+// Import creates the users of rows in one transaction, with a savepoint per
+// row so that a failed row does not take the others with it. In atomic mode
+// any failure rolls everything back, and a dry run always does, after the
+// database has had its say on every row. The passwords are hashed before
+// the transaction, which is bounded by the query timeout and run again
+// when it deadlocks.
+func Import(ctx context.Context, rows []*ImportRow, atomic bool, dryRun bool) (results []*ImportResult, committed bool, err error) {
+	ctx, span := tracer.Start(ctx, "users.Import")
+	defer tracing.End(span, &err)
+
+	// Nothing is kept from a dry run, so it can do without the cost of
+	// real hashes.
+	hashes := make([]string, len(rows))
+	for i, row := range rows {
+		hashes[i] = "dry-run"
+		if !dryRun {
+			if hashes[i], err = pwd.Hash(ctx, row.Input.Password); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		st, err := tx.PrepareContext(ctx, "INSERT INTO users(name, password, age, email, reg_date, updated_at) VALUES(?, ?, ?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+
+		failed := false
+		results = make([]*ImportResult, 0, len(rows))
+		for i, row := range rows {
+			res := &ImportResult{Row: row.Row, Name: row.Input.Name}
+			results = append(results, res)
+
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT row"); err != nil {
+				return err
+			}
+
+			tmpAge := sql.NullInt64{}
+			if row.Input.Age > 0 {
+				tmpAge.Valid = true
+				tmpAge.Int64 = row.Input.Age
+			}
+			now := time.Now()
+			result, err := st.ExecContext(ctx, row.Input.Name, hashes[i], tmpAge, nullString(row.Input.Email), now, now)
+			if err != nil {
+				// A deadlock has rolled back the whole transaction, not
+				// just the row, so it all has to be tried again.
+				if db.IsRetryable(err) {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT row"); err != nil {
+					return err
+				}
+				res.Error = rowError(ctx, row.Input, err)
+				failed = true
+				continue
+			}
+			if !dryRun {
+				res.ID, _ = result.LastInsertId()
+				if err := audit.Commit(ctx, tx, res.ID, map[string]any{"name": res.Name}); err != nil {
+					return err
+				}
+			}
+		}
+
+		if dryRun || (atomic && failed) {
+			return errKept
+		}
+		return nil
+	})
+	if err == errKept {
+		if !dryRun {
+			for _, res := range results {
+				res.ID = 0
+			}
+		}
+		return results, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return results, true, nil
+}
+
+// errKept makes WithTx roll back an import that is not to be kept.
+var errKept = errors.New("Import: Not Kept")
+
+// rowError says what the database had against a row, without the details
+// of the error, which stay in the log.
+func rowError(ctx context.Context, uIn *Input, err error) string {
+	if db.IsDuplicate(err) {
+		return fmt.Sprintf("User(%s) Duplicate", uIn.Name)
+	}
+	log.DebugContext(ctx, "import row failed", "name", uIn.Name, "err", err)
+	return fmt.Sprintf("User(%s) Invalid", uIn.Name)
+}
+
+// Each calls fn for every user in id order, reading them one row at a time
+// so that the table never has to fit in memory. Unlike Import, it is
+// bounded by ctx alone, as it runs as long as the table takes.
+func Each(ctx context.Context, fn func(uOut *Output) error) (err error) {
+	ctx, span := tracer.Start(ctx, "users.Each")
+	defer tracing.End(span, &err)
+
+	conn := db.Reader(ctx)
+	st, err := conn.PrepareContext(ctx, "SELECT "+userFields+" FROM users WHERE "+alive+" ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	rows, err := st.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(toOut(u)); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,306 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"time"
+
+	"echo-demo/audit"
+	"echo-demo/config"
+	"echo-demo/db"
+	"echo-demo/tracing"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+var (
+	ErrCodeInvalid     = errors.New("Users: Code Invalid")
+	ErrTOTPEnabled     = errors.New("Users: TOTP Already Enabled")
+	ErrTOTPNotEnrolled = errors.New("Users: TOTP Not Enrolled")
+)
+
+const recoveryCodeCount = 10
+
+// The period and skew of totp.Validate.
+const (
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+type CodeInput struct {
+	Code string `json:"code" form:"code" xml:"code" validate:"required"`
+}
+
+type ChallengeInput struct {
+	Challenge string `json:"challenge" form:"challenge" xml:"challenge" validate:"required"`
+	Code      string `json:"code" form:"code" xml:"code" validate:"required"`
+}
+
+type ChallengeOutput struct {
+	XMLName   xml.Name `json:"-" xml:"challenge"`
+	Challenge string   `json:"challenge" xml:"challenge"`
+	ExpiresIn int64    `json:"expires_in" xml:"expires_in"`
+}
+
+type EnrollOutput struct {
+	XMLName xml.Name `json:"-" xml:"enrollment"`
+	Secret  string   `json:"secret" xml:"secret"`
+	URI     string   `json:"uri" xml:"uri"`
+	QRCode  string   `json:"qr_code" xml:"qr_code"`
+}
+
+type RecoveryOutput struct {
+	XMLName       xml.Name `json:"-" xml:"recovery"`
+	RecoveryCodes []string `json:"recovery_codes" xml:"recovery_code"`
+}
+
+// EnrollTOTP generates a new pending TOTP secret for the user. The secret
+// is not used for login until it is activated with ActivateTOTP.
+func EnrollTOTP(ctx context.Context, id int64) (key *otp.Key, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.EnrollTOTP")
+	defer tracing.End(span, &err)
+
+	u, err := getOneByID(ctx, db.Conn(), id)
+	if err != nil {
+		return nil, err
+	}
+	if u.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+
+	key, err = totp.Generate(totp.GenerateOpts{
+		Issuer:      config.TOTPIssuer(),
+		AccountName: u.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled = FALSE", key.Secret(), id); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// TOTPKey rebuilds the key of a pending or active enrollment, so that the
+// otpauth URI and QR code can be fetched again.
+func TOTPKey(ctx context.Context, id int64) (key *otp.Key, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.TOTPKey")
+	defer tracing.End(span, &err)
+
+	u, err := getOneByID(ctx, db.Conn(), id)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.TOTPSecret) == 0 {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(u.TOTPSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      config.TOTPIssuer(),
+		AccountName: u.Name,
+		Secret:      secret,
+	})
+}
+
+// ActivateTOTP checks the first code of a pending enrollment, enables 2FA
+// and returns a fresh set of one-time recovery codes.
+func ActivateTOTP(ctx context.Context, id int64, code string) (codes []string, err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.ActivateTOTP")
+	defer tracing.End(span, &err)
+
+	codes = make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		rc, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, rc)
+	}
+
+	err = db.WithTx(ctx, func(tx *sql.Tx) error {
+		u, err := getOneByID(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if u.TOTPEnabled {
+			return ErrTOTPEnabled
+		}
+		if len(u.TOTPSecret) == 0 {
+			return ErrTOTPNotEnrolled
+		}
+		step := totpStep(code, u.TOTPSecret, time.Now())
+		if step < 0 {
+			return ErrCodeInvalid
+		}
+		if err := acceptStep(ctx, tx, id, step); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		for _, rc := range codes {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes(user_id, code_hash) VALUES(?, ?)", id, hashRecoveryCode(rc)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_enabled = TRUE, version = version + 1, updated_at = ? WHERE id = ?", time.Now(), id); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off after checking a current code, and drops the
+// secret together with any remaining recovery codes.
+func DisableTOTP(ctx context.Context, id int64, code string) (err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.DisableTOTP")
+	defer tracing.End(span, &err)
+
+	return db.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := verifyTOTP(ctx, tx, id, code); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL, version = version + 1, updated_at = ? WHERE id = ?", time.Now(), id); err != nil {
+			return err
+		}
+		return audit.Commit(ctx, tx, id, nil)
+	})
+}
+
+// VerifyTOTP accepts either a current TOTP code or an unused recovery code.
+// Either works once: a recovery code is consumed on success, a TOTP code
+// is refused from then on, along with those of earlier time steps.
+func VerifyTOTP(ctx context.Context, id int64, code string) (err error) {
+	ctx, cancel := db.Timeout(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "users.VerifyTOTP")
+	defer tracing.End(span, &err)
+
+	return verifyTOTP(ctx, db.Writer(ctx), id, code)
+}
+
+func verifyTOTP(ctx context.Context, q db.Querier, id int64, code string) error {
+	u, err := getOneByID(ctx, q, id)
+	if err != nil {
+		return err
+	}
+	if !u.TOTPEnabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	if step := totpStep(code, u.TOTPSecret, time.Now()); step >= 0 {
+		return acceptStep(ctx, q, id, step)
+	}
+
+	return useRecoveryCode(ctx, q, id, code)
+}
+
+// totpStep returns the time step around now that code belongs to, with
+// the skew totp.Validate allows, or -1.
+func totpStep(code, secret string, now time.Time) int64 {
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	current := now.Unix() / totpPeriod
+	for step := current + totpSkew; step >= current-totpSkew; step-- {
+		want, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), opts)
+		if err == nil && subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return -1
+}
+
+// acceptStep records step as the last one a code was accepted for, unless
+// it or a later one was already, so that no code can be replayed.
+func acceptStep(ctx context.Context, q db.Querier, id, step int64) error {
+	st, err := q.PrepareContext(ctx, "UPDATE users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	result, err := st.ExecContext(ctx, step, id, step)
+	if err != nil {
+		return err
+	}
+	if num, _ := result.RowsAffected(); num == 0 {
+		return ErrCodeInvalid
+	}
+
+	return nil
+}
+
+func useRecoveryCode(ctx context.Context, q db.Querier, id int64, code string) error {
+	st, err := q.PrepareContext(ctx, "UPDATE recovery_codes SET used_at = now() WHERE user_id = ? AND code_hash = ? AND used_at IS NULL")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	result, err := st.ExecContext(ctx, id, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if num, _ := result.RowsAffected(); num == 0 {
+		return ErrCodeInvalid
+	}
+
+	return nil
+}
+
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+	return code[:4] + "-" + code[4:], nil
+}
+
+// Recovery codes are random, so a plain SHA-256 is enough to keep them
+// useless when the table leaks.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
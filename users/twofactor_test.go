@@ -0,0 +1,131 @@
+package users
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"echo-demo/db/dbtest"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const testSecret = "JBSWY3DPEHPK3PXP"
+
+func codeAt(t *testing.T, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(testSecret, at, totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPStep(t *testing.T) {
+	now := time.Unix(1_700_000_015, 0)
+	current := now.Unix() / totpPeriod
+	period := totpPeriod * time.Second
+
+	tests := []struct {
+		name string
+		code string
+		want int64
+	}{
+		{"current", codeAt(t, now), current},
+		{"previous", codeAt(t, now.Add(-period)), current - 1},
+		{"next", codeAt(t, now.Add(period)), current + 1},
+		{"too old", codeAt(t, now.Add(-2*period)), -1},
+		{"too new", codeAt(t, now.Add(2*period)), -1},
+		{"empty", "", -1},
+		{"not a code", "abcdef", -1},
+	}
+	for _, tt := range tests {
+		if got := totpStep(tt.code, testSecret, now); got != tt.want {
+			t.Errorf("%s: step = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// codeDB keeps the last accepted step of user 1 and its recovery codes,
+// like users and recovery_codes.
+func codeDB(lastStep *int64, codes map[string]bool) *dbtest.DB {
+	return &dbtest.DB{
+		Exec: map[string]func([]driver.Value) (int64, error){
+			"UPDATE users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)": func(args []driver.Value) (int64, error) {
+				step := args[0].(int64)
+				if args[1].(int64) != 1 || (lastStep != nil && *lastStep >= step) {
+					return 0, nil
+				}
+				lastStep = &step
+				return 1, nil
+			},
+			"UPDATE recovery_codes SET used_at = now() WHERE user_id = ? AND code_hash = ? AND used_at IS NULL": func(args []driver.Value) (int64, error) {
+				hash := args[1].(string)
+				if used, ok := codes[hash]; args[0].(int64) != 1 || !ok || used {
+					return 0, nil
+				}
+				codes[hash] = true
+				return 1, nil
+			},
+		},
+	}
+}
+
+// A code is good once, and none from the same or an earlier step after it.
+func TestAcceptStep(t *testing.T) {
+	conn := codeDB(nil, nil).Open(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		id   int64
+		step int64
+		err  error
+	}{
+		{1, 100, nil},
+		{1, 100, ErrCodeInvalid},
+		{1, 99, ErrCodeInvalid},
+		{1, 101, nil},
+		{1, 100, ErrCodeInvalid},
+		{2, 102, ErrCodeInvalid},
+	}
+	for i, tt := range tests {
+		if err := acceptStep(ctx, conn, tt.id, tt.step); !errors.Is(err, tt.err) {
+			t.Errorf("%d: user %d step %d: err = %v, want %v", i, tt.id, tt.step, err, tt.err)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	code, err := newRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}$`).MatchString(code) {
+		t.Errorf("code %q is not in the xxxx-xxxx form", code)
+	}
+
+	codes := map[string]bool{hashRecoveryCode("abcd-efgh"): false}
+	conn := codeDB(nil, codes).Open(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		id   int64
+		code string
+		err  error
+	}{
+		{"other user", 2, "abcd-efgh", ErrCodeInvalid},
+		{"typed loosely", 1, " ABCDEFGH ", nil},
+		{"again", 1, "abcd-efgh", ErrCodeInvalid},
+		{"unknown", 1, "hgfe-dcba", ErrCodeInvalid},
+	}
+	for _, tt := range tests {
+		if err := useRecoveryCode(ctx, conn, tt.id, tt.code); !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package vk
+
+import (
+	"context"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("echo-demo/vk")
+
+// traced gives each command a span of its own, named after the command.
+// Arguments stay out of it, they may be anything.
+type traced struct {
+	valkey.Client
+}
+
+func (t traced) Do(ctx context.Context, cmd valkey.Completed) valkey.ValkeyResult {
+	ctx, span := start(ctx, cmd.Commands(), false)
+	resp := t.Client.Do(ctx, cmd)
+	end(span, resp.Error())
+	return resp
+}
+
+func (t traced) DoMulti(ctx context.Context, multi ...valkey.Completed) []valkey.ValkeyResult {
+	spans := make([]trace.Span, len(multi))
+	for i := range multi {
+		_, spans[i] = start(ctx, multi[i].Commands(), false)
+	}
+	resps := t.Client.DoMulti(ctx, multi...)
+	for i, resp := range resps {
+		end(spans[i], resp.Error())
+	}
+	return resps
+}
+
+func (t traced) DoCache(ctx context.Context, cmd valkey.Cacheable, ttl time.Duration) valkey.ValkeyResult {
+	ctx, span := start(ctx, cmd.Commands(), true)
+	resp := t.Client.DoCache(ctx, cmd, ttl)
+	span.SetAttributes(attribute.Bool("db.cache_hit", resp.IsCacheHit()))
+	end(span, resp.Error())
+	return resp
+}
+
+func (t traced) DoMultiCache(ctx context.Context, multi ...valkey.CacheableTTL) []valkey.ValkeyResult {
+	spans := make([]trace.Span, len(multi))
+	for i := range multi {
+		_, spans[i] = start(ctx, multi[i].Cmd.Commands(), true)
+	}
+	resps := t.Client.DoMultiCache(ctx, multi...)
+	for i, resp := range resps {
+		spans[i].SetAttributes(attribute.Bool("db.cache_hit", resp.IsCacheHit()))
+		end(spans[i], resp.Error())
+	}
+	return resps
+}
+
+func start(ctx context.Context, args []string, cached bool) (context.Context, trace.Span) {
+	name := "valkey"
+	if len(args) > 0 {
+		name = args[0]
+	}
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemRedis,
+		semconv.DBOperationName(name),
+		attribute.Bool("db.client_cache", cached),
+	))
+}
+
+func end(span trace.Span, err error) {
+	// A missing key is an answer, not a failure.
+	if err != nil && !valkey.IsValkeyNil(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -1,6 +1,7 @@
 package vk
 
 import (
+	"context"
 	"echo-demo/config"
 
 	"github.com/valkey-io/valkey-go"
@@ -9,13 +10,17 @@ import (
 var client valkey.Client
 
 func ClientInit() error {
-	cli, err := valkey.NewClient(valkey.MustParseURL(config.ValkeyURL()))
+	opt, err := valkey.ParseURL(config.ValkeyURL())
 	if err != nil {
-		cli.Close()
 		return err
 	}
 
-	client = cli
+	cli, err := valkey.NewClient(opt)
+	if err != nil {
+		return err
+	}
+
+	client = traced{cli}
 
 	return nil
 }
@@ -23,3 +28,8 @@ func ClientInit() error {
 func Client() valkey.Client {
 	return client
 }
+
+// Ping checks the server.
+func Ping(ctx context.Context) error {
+	return client.Do(ctx, client.B().Ping().Build()).Error()
+}